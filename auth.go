@@ -0,0 +1,304 @@
+package orchestra
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ghetzel/go-stockutil/typeutil"
+)
+
+type AuthType string
+
+const (
+	OAuth2Auth AuthType = `oauth2`
+	HMACAuth   AuthType = `hmac`
+	SigV4Auth  AuthType = `aws_sigv4`
+)
+
+type OAuth2Grant string
+
+const (
+	ClientCredentialsGrant OAuth2Grant = `client_credentials`
+	RefreshTokenGrant      OAuth2Grant = `refresh_token`
+)
+
+var DefaultAuthRefreshBefore = 60 * time.Second
+
+// DefaultAuthTokenTimeout bounds how long a token request may take.
+var DefaultAuthTokenTimeout = 30 * time.Second
+
+var registeredAuthProfiles = make(map[string]*AuthConfig)
+var authProfilesLock sync.RWMutex
+
+// RegisterAuthProfile makes an auth configuration available to any endpoint that refers to it by
+// name.  Every endpoint using a profile shares its tokens.
+func RegisterAuthProfile(name string, auth *AuthConfig) {
+	authProfilesLock.Lock()
+	defer authProfilesLock.Unlock()
+
+	registeredAuthProfiles[name] = auth
+}
+
+// AuthConfig describes how to authenticate requests to an endpoint, either inline or by naming a
+// shared profile.  OAuth2 tokens are obtained from the token URL using the client credentials grant,
+// or the refresh token grant if a refresh token is given, and are reused until shortly before they
+// expire.  HTTP requests can instead be signed with an HMAC or with AWS Signature Version 4, once
+// they have been fully rendered.
+type AuthConfig struct {
+	Profile       string         `yaml:"profile,omitempty"        json:"profile,omitempty"`
+	Type          AuthType       `yaml:"type,omitempty"           json:"type,omitempty"`
+	Grant         OAuth2Grant    `yaml:"grant,omitempty"          json:"grant,omitempty"`
+	TokenURL      string         `yaml:"token_url,omitempty"      json:"token_url,omitempty"`
+	ClientID      string         `yaml:"client_id,omitempty"      json:"client_id,omitempty"`
+	ClientSecret  string         `yaml:"client_secret,omitempty"  json:"client_secret,omitempty"`
+	RefreshToken  string         `yaml:"refresh_token,omitempty"  json:"refresh_token,omitempty"`
+	Scopes        []string       `yaml:"scopes,omitempty"         json:"scopes,omitempty"`
+	Params        map[string]any `yaml:"params,omitempty"         json:"params,omitempty"`
+	BasicAuth     bool           `yaml:"basic_auth,omitempty"     json:"basic_auth,omitempty"`
+	RefreshBefore string         `yaml:"refresh_before,omitempty" json:"refresh_before,omitempty"`
+	HMAC          *HMACConfig    `yaml:"hmac,omitempty"           json:"hmac,omitempty"`
+	AWS           *SigV4Config   `yaml:"aws,omitempty"            json:"aws,omitempty"`
+	token         *oauth2Token
+	lock          sync.Mutex
+}
+
+type oauth2Token struct {
+	AccessToken  string `json:"access_token"`
+	TokenType    string `json:"token_type"`
+	RefreshToken string `json:"refresh_token"`
+	ExpiresIn    int64  `json:"expires_in"`
+	expiresAt    time.Time
+	lifetime     time.Duration
+}
+
+// resolve returns the auth configuration to use: the named profile if there is one, or else this
+// configuration itself.
+func (auth *AuthConfig) resolve() (*AuthConfig, error) {
+	if auth == nil || auth.Profile == `` {
+		return auth, nil
+	}
+
+	authProfilesLock.RLock()
+	defer authProfilesLock.RUnlock()
+
+	if profile, ok := registeredAuthProfiles[auth.Profile]; ok && profile != nil {
+		if profile.Profile != `` {
+			return nil, fmt.Errorf("auth profile %q cannot refer to another profile", auth.Profile)
+		}
+
+		return profile, nil
+	} else {
+		return nil, fmt.Errorf("undefined auth profile %q", auth.Profile)
+	}
+}
+
+// authType returns the configured auth type, or else the type implied by which settings are given.
+func (auth *AuthConfig) authType() AuthType {
+	if auth.Type != `` {
+		return auth.Type
+	} else if auth.HMAC != nil {
+		return HMACAuth
+	} else if auth.AWS != nil {
+		return SigV4Auth
+	} else {
+		return OAuth2Auth
+	}
+}
+
+func (auth *AuthConfig) grant() OAuth2Grant {
+	if auth.Grant != `` {
+		return auth.Grant
+	} else if auth.RefreshToken != `` {
+		return RefreshTokenGrant
+	} else {
+		return ClientCredentialsGrant
+	}
+}
+
+// refreshBefore returns how long before a token with the given lifetime expires that it should be
+// replaced.  This is at most half of its lifetime, so short-lived tokens are still reused.
+func (auth *AuthConfig) refreshBefore(lifetime time.Duration) time.Duration {
+	var margin = DefaultAuthRefreshBefore
+
+	if d := typeutil.Duration(auth.RefreshBefore); d > 0 {
+		margin = d
+	}
+
+	return min(margin, lifetime/2)
+}
+
+// authorize adds credentials to the given request headers, returning a new set of headers.  Tokens
+// are requested using the endpoint's HTTP client.
+func (auth *AuthConfig) authorize(ctx context.Context, endpoint *Endpoint, headers map[string]any) (map[string]any, error) {
+	var out = make(map[string]any, len(headers)+1)
+
+	for k, v := range headers {
+		out[k] = v
+	}
+
+	switch auth.authType() {
+	case OAuth2Auth:
+		if token, err := auth.accessToken(ctx, endpoint); err == nil {
+			out[`Authorization`] = `Bearer ` + token
+		} else {
+			return nil, err
+		}
+	case HMACAuth, SigV4Auth:
+		// signed once the request has been built; see sign
+	default:
+		return nil, fmt.Errorf("unsupported auth type %q", auth.Type)
+	}
+
+	return out, nil
+}
+
+// invalidate discards the cached token sent with the given (rejected) request headers so the next
+// request obtains a new one.  A token that has already been replaced (e.g.: by a concurrent
+// request) is left alone.
+func (auth *AuthConfig) invalidate(sent map[string]any) {
+	auth.lock.Lock()
+	defer auth.lock.Unlock()
+
+	if auth.token != nil && typeutil.String(sent[`Authorization`]) == `Bearer `+auth.token.AccessToken {
+		auth.token.expiresAt = time.Time{}
+	}
+}
+
+// authorize adds the endpoint's credentials (if it has any) to the given request headers.
+func (endpoint *Endpoint) authorize(ctx context.Context, headers map[string]any) (map[string]any, error) {
+	if auth, err := endpoint.Auth.resolve(); err != nil {
+		return nil, err
+	} else if auth != nil && auth.signs() {
+		return nil, fmt.Errorf("auth type %q is only supported by HTTP endpoints", auth.authType())
+	} else if auth != nil {
+		return auth.authorize(ctx, endpoint, headers)
+	} else {
+		return headers, nil
+	}
+}
+
+// accessToken returns a valid access token, fetching a new one if there isn't one cached or it is
+// about to expire.
+func (auth *AuthConfig) accessToken(ctx context.Context, endpoint *Endpoint) (string, error) {
+	auth.lock.Lock()
+	defer auth.lock.Unlock()
+
+	if token := auth.token; token != nil && time.Until(token.expiresAt) > auth.refreshBefore(token.lifetime) {
+		return token.AccessToken, nil
+	}
+
+	var client *http.Client
+
+	if c, err := endpoint.httpClient(ctx); err == nil {
+		client = c
+	} else {
+		return ``, fmt.Errorf("oauth2: %v", err)
+	}
+
+	var form = make(url.Values)
+	var grant = auth.grant()
+	var creds = make(map[string]string)
+
+	for k, v := range map[string]string{
+		`token_url`:     auth.TokenURL,
+		`client_id`:     auth.ClientID,
+		`client_secret`: auth.ClientSecret,
+		`refresh_token`: auth.RefreshToken,
+	} {
+		if value, err := resolveSecretString(ctx, v); err == nil {
+			creds[k] = value
+		} else {
+			return ``, fmt.Errorf("oauth2: %v", err)
+		}
+	}
+
+	form.Set(`grant_type`, string(grant))
+
+	switch grant {
+	case RefreshTokenGrant:
+		var refresh = creds[`refresh_token`]
+
+		if auth.token != nil && auth.token.RefreshToken != `` {
+			refresh = auth.token.RefreshToken
+		}
+
+		form.Set(`refresh_token`, refresh)
+	case ClientCredentialsGrant:
+	default:
+		return ``, fmt.Errorf("oauth2: unsupported grant %q", grant)
+	}
+
+	if len(auth.Scopes) > 0 {
+		form.Set(`scope`, strings.Join(auth.Scopes, ` `))
+	}
+
+	if params, err := resolveSecretMap(ctx, auth.Params); err == nil {
+		for k, v := range params {
+			form.Set(k, typeutil.String(v))
+		}
+	} else {
+		return ``, fmt.Errorf("oauth2: %v", err)
+	}
+
+	if !auth.BasicAuth {
+		form.Set(`client_id`, creds[`client_id`])
+
+		if creds[`client_secret`] != `` {
+			form.Set(`client_secret`, creds[`client_secret`])
+		}
+	}
+
+	var tokenCtx, cancel = context.WithTimeout(ctx, DefaultAuthTokenTimeout)
+	defer cancel()
+
+	var req, err = http.NewRequestWithContext(tokenCtx, http.MethodPost, creds[`token_url`], strings.NewReader(form.Encode()))
+
+	if err != nil {
+		return ``, fmt.Errorf("oauth2: %v", err)
+	}
+
+	req.Header.Set(`Content-Type`, `application/x-www-form-urlencoded`)
+	req.Header.Set(`Accept`, `application/json`)
+
+	if auth.BasicAuth {
+		req.SetBasicAuth(url.QueryEscape(creds[`client_id`]), url.QueryEscape(creds[`client_secret`]))
+	}
+
+	if res, err := client.Do(req); err == nil {
+		defer res.Body.Close()
+
+		var token oauth2Token
+
+		if res.StatusCode >= 400 {
+			return ``, fmt.Errorf("oauth2: token request failed: HTTP %v", res.Status)
+		} else if err := json.NewDecoder(res.Body).Decode(&token); err != nil {
+			return ``, fmt.Errorf("oauth2: invalid token response: %v", err)
+		} else if token.AccessToken == `` {
+			return ``, fmt.Errorf("oauth2: token response did not include an access token")
+		}
+
+		if token.ExpiresIn > 0 {
+			token.lifetime = time.Duration(token.ExpiresIn) * time.Second
+		} else {
+			// no expiry given; use it until it's rejected
+			token.lifetime = 24 * 365 * time.Hour
+		}
+
+		token.expiresAt = time.Now().Add(token.lifetime)
+
+		if token.RefreshToken == `` && auth.token != nil {
+			token.RefreshToken = auth.token.RefreshToken
+		}
+
+		auth.token = &token
+		return token.AccessToken, nil
+	} else {
+		return ``, fmt.Errorf("oauth2: %v", err)
+	}
+}
@@ -0,0 +1,218 @@
+package orchestra
+
+import (
+	"encoding/pem"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ghetzel/go-stockutil/httputil"
+	"github.com/ghetzel/testify/require"
+)
+
+// testOAuth2Server issues numbered tokens and serves an API that only accepts the tokens it has
+// issued and not since revoked.
+type testOAuth2Server struct {
+	issued    int
+	expiresIn int
+	grants    []string
+	refreshes []string
+	valid     map[string]bool
+	lock      sync.Mutex
+}
+
+func (ts *testOAuth2Server) start(t *testing.T) (*httptest.Server, *httptest.Server) {
+	ts.valid = make(map[string]bool)
+
+	var tokens = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ts.lock.Lock()
+		defer ts.lock.Unlock()
+
+		r.ParseForm()
+
+		var id, secret, basic = r.BasicAuth()
+
+		if !basic {
+			id, secret = r.PostForm.Get(`client_id`), r.PostForm.Get(`client_secret`)
+		}
+
+		if id != `orchestra` || secret != `s3cret` {
+			http.Error(w, `bad client`, http.StatusUnauthorized)
+			return
+		}
+
+		ts.issued += 1
+		ts.grants = append(ts.grants, r.PostForm.Get(`grant_type`))
+		ts.refreshes = append(ts.refreshes, r.PostForm.Get(`refresh_token`))
+
+		var token = fmt.Sprintf("token-%d", ts.issued)
+		ts.valid[token] = true
+
+		httputil.RespondJSON(w, map[string]any{
+			`access_token`:  token,
+			`token_type`:    `Bearer`,
+			`expires_in`:    ts.expiresIn,
+			`refresh_token`: fmt.Sprintf("refresh-%d", ts.issued),
+		})
+	}))
+
+	var api = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ts.lock.Lock()
+		defer ts.lock.Unlock()
+
+		var token = r.Header.Get(`Authorization`)
+
+		if len(token) > 7 && ts.valid[token[7:]] {
+			httputil.RespondJSON(w, map[string]any{`token`: token[7:]})
+		} else {
+			http.Error(w, `unauthorized`, http.StatusUnauthorized)
+		}
+	}))
+
+	t.Cleanup(tokens.Close)
+	t.Cleanup(api.Close)
+
+	return tokens, api
+}
+
+func (ts *testOAuth2Server) revokeAll() {
+	ts.lock.Lock()
+	defer ts.lock.Unlock()
+
+	ts.valid = make(map[string]bool)
+}
+
+func TestOAuth2ClientCredentialsProfile(t *testing.T) {
+	var assert = require.New(t)
+	var ts = &testOAuth2Server{expiresIn: 3600}
+	var tokens, api = ts.start(t)
+
+	RegisterAuthProfile(`test-cc`, &AuthConfig{
+		TokenURL:     tokens.URL,
+		ClientID:     `orchestra`,
+		ClientSecret: `s3cret`,
+		Scopes:       []string{`read`, `write`},
+	})
+
+	var first = &Endpoint{URL: api.URL, Auth: &AuthConfig{Profile: `test-cc`}}
+	var second = &Endpoint{URL: api.URL + `/other`, Auth: &AuthConfig{Profile: `test-cc`}}
+
+	for _, endpoint := range []*Endpoint{first, second, first} {
+		var response, err = NewQueryOptions().Query(endpoint)
+		assert.NoError(err)
+		assert.Equal(map[string]any{`token`: `token-1`}, response.Result)
+	}
+
+	// tokens are shared by every endpoint using the profile
+	assert.Equal(1, ts.issued)
+	assert.Equal([]string{`client_credentials`}, ts.grants)
+
+	// a rejected token is replaced and the request retried once
+	ts.revokeAll()
+
+	var response, err = NewQueryOptions().Query(second)
+	assert.NoError(err)
+	assert.Equal(map[string]any{`token`: `token-2`}, response.Result)
+	assert.Equal(2, ts.issued)
+
+	_, err = NewQueryOptions().Query(&Endpoint{URL: api.URL, Auth: &AuthConfig{Profile: `nope`}})
+	assert.Error(err)
+	assert.Contains(err.Error(), `undefined auth profile "nope"`)
+}
+
+func TestOAuth2RefreshTokenGrant(t *testing.T) {
+	var assert = require.New(t)
+	var ts = &testOAuth2Server{expiresIn: 30}
+	var tokens, api = ts.start(t)
+
+	var endpoint = &Endpoint{
+		URL: api.URL,
+		Auth: &AuthConfig{
+			TokenURL:     tokens.URL,
+			ClientID:     `orchestra`,
+			ClientSecret: `s3cret`,
+			RefreshToken: `refresh-0`,
+			BasicAuth:    true,
+		},
+	}
+
+	// the refresh window is clamped to half of a short-lived token's lifetime, so it is reused
+	for range 3 {
+		var response, err = NewQueryOptions().Query(endpoint)
+		assert.NoError(err)
+		assert.Equal(map[string]any{`token`: `token-1`}, response.Result)
+	}
+
+	// tokens expiring within the refresh window are refreshed before the next request
+	endpoint.Auth.token.expiresAt = time.Now().Add(10 * time.Second)
+
+	for range 2 {
+		var response, err = NewQueryOptions().Query(endpoint)
+		assert.NoError(err)
+		assert.Equal(map[string]any{`token`: `token-2`}, response.Result)
+	}
+
+	assert.Equal([]string{`refresh_token`, `refresh_token`}, ts.grants)
+	assert.Equal([]string{`refresh-0`, `refresh-1`}, ts.refreshes)
+
+	endpoint.Auth.RefreshBefore = `5s`
+	endpoint.Auth.token.expiresAt = time.Now().Add(10 * time.Second)
+
+	var response, err = NewQueryOptions().Query(endpoint)
+	assert.NoError(err)
+	assert.Equal(map[string]any{`token`: `token-2`}, response.Result)
+
+	endpoint.Auth.token.expiresAt = time.Now()
+	endpoint.Auth.ClientSecret = `wrong`
+
+	_, err = NewQueryOptions().Query(endpoint)
+	assert.Error(err)
+	assert.Contains(err.Error(), `token request failed`)
+}
+
+func TestOAuth2TokenRequestUsesEndpointTLS(t *testing.T) {
+	var assert = require.New(t)
+	var ts = &testOAuth2Server{expiresIn: 3600}
+	var plainTokens, api = ts.start(t)
+
+	// the token URL is only reachable by a client trusting the test server's certificate
+	var tokens = httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var proxied, _ = http.NewRequest(r.Method, plainTokens.URL, r.Body)
+		proxied.Header = r.Header
+
+		if res, err := http.DefaultClient.Do(proxied); err == nil {
+			defer res.Body.Close()
+			w.WriteHeader(res.StatusCode)
+			io.Copy(w, res.Body)
+		} else {
+			http.Error(w, err.Error(), http.StatusBadGateway)
+		}
+	}))
+
+	defer tokens.Close()
+
+	var auth = &AuthConfig{
+		TokenURL:     tokens.URL,
+		ClientID:     `orchestra`,
+		ClientSecret: `s3cret`,
+	}
+
+	var response, err = NewQueryOptions().Query(&Endpoint{URL: api.URL, Auth: auth})
+	assert.Error(err)
+	assert.Contains(err.Error(), `certificate`)
+
+	response, err = NewQueryOptions().Query(&Endpoint{
+		URL:  api.URL,
+		Auth: auth,
+		TLS: &TLSConfig{
+			CA: string(pem.EncodeToMemory(&pem.Block{Type: `CERTIFICATE`, Bytes: tokens.Certificate().Raw})),
+		},
+	})
+
+	assert.NoError(err)
+	assert.Equal(map[string]any{`token`: `token-1`}, response.Result)
+}
@@ -0,0 +1,365 @@
+package orchestra
+
+import (
+	"container/list"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/ghetzel/go-stockutil/log"
+	"github.com/ghetzel/go-stockutil/sliceutil"
+	"github.com/ghetzel/go-stockutil/typeutil"
+)
+
+const DefaultCacheMaxEntries = 1024
+
+type cacheContextKey struct{}
+
+// WithCacheBypass returns a context that causes endpoint caches to be skipped when looking up
+// responses.  Fresh responses retrieved under this context are still stored in the cache.
+func WithCacheBypass(ctx context.Context) context.Context {
+	return context.WithValue(ctx, cacheContextKey{}, true)
+}
+
+func isCacheBypassed(ctx context.Context) bool {
+	var v, _ = ctx.Value(cacheContextKey{}).(bool)
+	return v
+}
+
+type CacheEntry struct {
+	Value     any       `json:"value"`
+	StoredAt  time.Time `json:"stored_at"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// CacheBackend stores decoded endpoint responses by key.
+type CacheBackend interface {
+	Get(key string) (*CacheEntry, bool)
+	Set(key string, entry *CacheEntry) error
+}
+
+// CacheConfig enables caching of an endpoint's decoded responses.  Entries are fresh for TTL, after
+// which they may still be served for up to Stale while a fresh copy is retrieved in the background.
+type CacheConfig struct {
+	TTL        string   `yaml:"ttl"                   json:"ttl"`
+	Stale      string   `yaml:"stale,omitempty"       json:"stale,omitempty"`
+	Backend    string   `yaml:"backend,omitempty"     json:"backend,omitempty"`
+	Path       string   `yaml:"path,omitempty"        json:"path,omitempty"`
+	MaxEntries int      `yaml:"max_entries,omitempty" json:"max_entries,omitempty"`
+	Key        []string `yaml:"key,omitempty"         json:"key,omitempty"`
+	backend    CacheBackend
+	backendErr error
+	backendOne sync.Once
+	refreshing sync.Map
+}
+
+type cacheLoadFunc func(ctx context.Context, meta QueryContext) (any, error)
+
+func (cache *CacheConfig) getBackend() (CacheBackend, error) {
+	cache.backendOne.Do(func() {
+		switch cache.Backend {
+		case ``, `memory`:
+			cache.backend = NewMemoryCache(cache.MaxEntries)
+		case `disk`:
+			var dir = cache.Path
+
+			if dir == `` {
+				dir = filepath.Join(ManagedConfigDir, `cache`)
+			}
+
+			cache.backend, cache.backendErr = NewDiskCache(dir, cache.MaxEntries)
+		default:
+			cache.backendErr = fmt.Errorf("unknown cache backend %q", cache.Backend)
+		}
+	})
+
+	return cache.backend, cache.backendErr
+}
+
+// validate checks that the TTL (and stale window, if any) are usable durations, since an entry stored
+// with no TTL would already have expired.
+func (cache *CacheConfig) validate() error {
+	if typeutil.Duration(cache.TTL) <= 0 {
+		return fmt.Errorf("cache: ttl must be a positive duration, got %q", cache.TTL)
+	} else if cache.Stale != `` && typeutil.Duration(cache.Stale) <= 0 {
+		return fmt.Errorf("cache: stale must be a positive duration, got %q", cache.Stale)
+	}
+
+	return nil
+}
+
+// key builds a cache key from the endpoint name and whichever parts of the rendered request are
+// named in cache.Key (all of them by default).
+func (cache *CacheConfig) key(endpoint *Endpoint, request map[string]any) string {
+	var parts = map[string]any{
+		`endpoint`: endpoint.Name,
+	}
+
+	for k, v := range request {
+		if len(cache.Key) == 0 || sliceutil.ContainsString(cache.Key, k) {
+			parts[k] = v
+		}
+	}
+
+	return requestKey(parts)
+}
+
+// requestKey returns a stable hash of the given request description.
+func requestKey(parts map[string]any) string {
+	var sum = sha256.Sum256([]byte(typeutil.JSON(parts)))
+
+	return hex.EncodeToString(sum[:])
+}
+
+func (cache *CacheConfig) store(key string, value any) {
+	if backend, err := cache.getBackend(); err == nil {
+		var now = time.Now()
+
+		if err := backend.Set(key, &CacheEntry{
+			Value:     value,
+			StoredAt:  now,
+			ExpiresAt: now.Add(typeutil.Duration(cache.TTL)),
+		}); err != nil {
+			log.Warningf("cache: %v", err)
+		}
+	}
+}
+
+// retrieve returns the cached value for key, calling load on a cache miss.  The outcome of the lookup
+// is recorded in meta under "cache".
+func (cache *CacheConfig) retrieve(ctx context.Context, endpoint *Endpoint, key string, load cacheLoadFunc, meta QueryContext) (any, error) {
+	var backend, err = cache.getBackend()
+
+	if err != nil {
+		return nil, err
+	} else if err := cache.validate(); err != nil {
+		return nil, err
+	}
+
+	if isCacheBypassed(ctx) {
+		meta[`cache`] = `bypass`
+	} else if entry, ok := backend.Get(key); ok {
+		var now = time.Now()
+
+		if now.Before(entry.ExpiresAt) {
+			meta[`cache`] = `hit`
+			return entry.Value, nil
+		} else if stale := typeutil.Duration(cache.Stale); stale > 0 && now.Before(entry.ExpiresAt.Add(stale)) {
+			meta[`cache`] = `stale`
+			cache.revalidate(ctx, endpoint, key, load)
+			return entry.Value, nil
+		}
+
+		meta[`cache`] = `miss`
+	} else {
+		meta[`cache`] = `miss`
+	}
+
+	if value, err := load(ctx, meta); err == nil {
+		cache.store(key, value)
+		return value, nil
+	} else {
+		return nil, err
+	}
+}
+
+// revalidate refreshes the entry for key in the background, unless a refresh is already underway.
+func (cache *CacheConfig) revalidate(ctx context.Context, endpoint *Endpoint, key string, load cacheLoadFunc) {
+	if _, busy := cache.refreshing.LoadOrStore(key, true); busy {
+		return
+	}
+
+	go func() {
+		defer cache.refreshing.Delete(key)
+
+		var refreshCtx, cancel = withTimeout(context.WithoutCancel(ctx), endpoint.Timeout)
+		defer cancel()
+
+		if value, err := load(refreshCtx, make(QueryContext)); err == nil {
+			cache.store(key, value)
+		} else {
+			warningf("cache: revalidate %s: %v", endpoint.Name, err)
+		}
+	}()
+}
+
+// MemoryCache is an in-memory CacheBackend that evicts the least recently used entry once it holds
+// more than its maximum number of entries.
+type MemoryCache struct {
+	max     int
+	order   *list.List
+	entries map[string]*list.Element
+	lock    sync.Mutex
+}
+
+type memoryCacheItem struct {
+	key   string
+	entry *CacheEntry
+}
+
+func NewMemoryCache(maxEntries int) *MemoryCache {
+	if maxEntries <= 0 {
+		maxEntries = DefaultCacheMaxEntries
+	}
+
+	return &MemoryCache{
+		max:     maxEntries,
+		order:   list.New(),
+		entries: make(map[string]*list.Element),
+	}
+}
+
+func (mem *MemoryCache) Get(key string) (*CacheEntry, bool) {
+	mem.lock.Lock()
+	defer mem.lock.Unlock()
+
+	if el, ok := mem.entries[key]; ok {
+		mem.order.MoveToFront(el)
+		return el.Value.(*memoryCacheItem).entry, true
+	}
+
+	return nil, false
+}
+
+func (mem *MemoryCache) Set(key string, entry *CacheEntry) error {
+	mem.lock.Lock()
+	defer mem.lock.Unlock()
+
+	if el, ok := mem.entries[key]; ok {
+		el.Value.(*memoryCacheItem).entry = entry
+		mem.order.MoveToFront(el)
+	} else {
+		mem.entries[key] = mem.order.PushFront(&memoryCacheItem{
+			key:   key,
+			entry: entry,
+		})
+	}
+
+	for mem.order.Len() > mem.max {
+		var oldest = mem.order.Back()
+
+		mem.order.Remove(oldest)
+		delete(mem.entries, oldest.Value.(*memoryCacheItem).key)
+	}
+
+	return nil
+}
+
+func (mem *MemoryCache) Len() int {
+	mem.lock.Lock()
+	defer mem.lock.Unlock()
+
+	return mem.order.Len()
+}
+
+// DiskCache is a CacheBackend that stores each entry as a JSON file in a directory.  Once it holds
+// more than its maximum number of entries, the least recently used files are removed.
+type DiskCache struct {
+	dir  string
+	max  int
+	lock sync.Mutex
+}
+
+func NewDiskCache(dir string, maxEntries int) (*DiskCache, error) {
+	if maxEntries <= 0 {
+		maxEntries = DefaultCacheMaxEntries
+	}
+
+	if err := os.MkdirAll(dir, 0700); err == nil {
+		return &DiskCache{
+			dir: dir,
+			max: maxEntries,
+		}, nil
+	} else {
+		return nil, fmt.Errorf("disk cache: %v", err)
+	}
+}
+
+func (disk *DiskCache) filename(key string) string {
+	return filepath.Join(disk.dir, key+`.json`)
+}
+
+func (disk *DiskCache) Get(key string) (*CacheEntry, bool) {
+	if data, err := os.ReadFile(disk.filename(key)); err == nil {
+		var entry CacheEntry
+
+		if err := json.Unmarshal(data, &entry); err == nil {
+			// the modification time tracks when the entry was last used
+			var now = time.Now()
+			os.Chtimes(disk.filename(key), now, now)
+
+			return &entry, true
+		} else {
+			log.Warningf("disk cache: %v", err)
+		}
+	} else if !errors.Is(err, os.ErrNotExist) {
+		log.Warningf("disk cache: %v", err)
+	}
+
+	return nil, false
+}
+
+func (disk *DiskCache) Set(key string, entry *CacheEntry) error {
+	if data, err := json.Marshal(entry); err == nil {
+		if tmp, err := os.CreateTemp(disk.dir, key+`.*.tmp`); err == nil {
+			defer os.Remove(tmp.Name())
+
+			if _, err := tmp.Write(data); err != nil {
+				tmp.Close()
+				return err
+			} else if err := tmp.Close(); err != nil {
+				return err
+			}
+
+			if err := os.Rename(tmp.Name(), disk.filename(key)); err != nil {
+				return err
+			}
+
+			return disk.evict()
+		} else {
+			return err
+		}
+	} else {
+		return err
+	}
+}
+
+// evict removes the least recently used entries beyond the maximum.
+func (disk *DiskCache) evict() error {
+	disk.lock.Lock()
+	defer disk.lock.Unlock()
+
+	var files, err = filepath.Glob(filepath.Join(disk.dir, `*.json`))
+
+	if err != nil || len(files) <= disk.max {
+		return err
+	}
+
+	var used = make(map[string]time.Time, len(files))
+
+	for _, file := range files {
+		if info, err := os.Stat(file); err == nil {
+			used[file] = info.ModTime()
+		}
+	}
+
+	slices.SortFunc(files, func(a string, b string) int {
+		return used[a].Compare(used[b])
+	})
+
+	for _, file := range files[:len(files)-disk.max] {
+		if err := os.Remove(file); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,235 @@
+package orchestra
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/ghetzel/go-stockutil/httputil"
+	"github.com/ghetzel/testify/require"
+)
+
+func testCountingServer() (*httptest.Server, *int32) {
+	var calls int32
+
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		httputil.RespondJSON(w, map[string]any{
+			`call`: atomic.AddInt32(&calls, 1),
+			`q`:    r.URL.Query().Get(`q`),
+		})
+	})), &calls
+}
+
+func TestCacheHitMissBypass(t *testing.T) {
+	var assert = require.New(t)
+	var server, calls = testCountingServer()
+	defer server.Close()
+
+	var endpoint = &Endpoint{
+		Name: `cached`,
+		URL:  server.URL,
+		Cache: &CacheConfig{
+			TTL: `1m`,
+		},
+	}
+
+	var query = func(ctx context.Context, q string) *QueryResponse {
+		var opts = NewQueryOptions()
+		opts.Params[`q`] = q
+
+		var response, err = opts.QueryWithContext(ctx, endpoint)
+		assert.NoError(err)
+		return response
+	}
+
+	var res = query(context.Background(), `a`)
+	assert.Equal(`miss`, res.Context[`cache`])
+	assert.EqualValues(1, atomic.LoadInt32(calls))
+
+	res = query(context.Background(), `a`)
+	assert.Equal(`hit`, res.Context[`cache`])
+	assert.EqualValues(1, atomic.LoadInt32(calls))
+
+	res = query(context.Background(), `b`)
+	assert.Equal(`miss`, res.Context[`cache`])
+	assert.EqualValues(2, atomic.LoadInt32(calls))
+
+	res = query(WithCacheBypass(context.Background()), `a`)
+	assert.Equal(`bypass`, res.Context[`cache`])
+	assert.EqualValues(3, atomic.LoadInt32(calls))
+
+	// the bypassed request refreshed the entry
+	res = query(context.Background(), `a`)
+	assert.Equal(`hit`, res.Context[`cache`])
+	assert.EqualValues(3, res.Result.(map[string]any)[`call`])
+}
+
+func TestCacheStaleWhileRevalidate(t *testing.T) {
+	var assert = require.New(t)
+	var server, calls = testCountingServer()
+	defer server.Close()
+
+	var endpoint = &Endpoint{
+		Name: `cached-stale`,
+		URL:  server.URL,
+		Cache: &CacheConfig{
+			TTL:   `20ms`,
+			Stale: `1m`,
+		},
+	}
+
+	var res, err = NewQueryOptions().Query(endpoint)
+	assert.NoError(err)
+	assert.Equal(`miss`, res.Context[`cache`])
+
+	time.Sleep(30 * time.Millisecond)
+
+	res, err = NewQueryOptions().Query(endpoint)
+	assert.NoError(err)
+	assert.Equal(`stale`, res.Context[`cache`])
+	assert.EqualValues(1, res.Result.(map[string]any)[`call`])
+
+	for i := 0; i < 100 && atomic.LoadInt32(calls) < 2; i++ {
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	time.Sleep(5 * time.Millisecond)
+
+	res, err = NewQueryOptions().Query(endpoint)
+	assert.NoError(err)
+	assert.Equal(`hit`, res.Context[`cache`])
+	assert.EqualValues(2, res.Result.(map[string]any)[`call`])
+}
+
+func TestMemoryCacheEviction(t *testing.T) {
+	var assert = require.New(t)
+	var mem = NewMemoryCache(2)
+
+	assert.NoError(mem.Set(`a`, &CacheEntry{Value: 1}))
+	assert.NoError(mem.Set(`b`, &CacheEntry{Value: 2}))
+
+	var _, ok = mem.Get(`a`)
+	assert.True(ok)
+
+	assert.NoError(mem.Set(`c`, &CacheEntry{Value: 3}))
+	assert.Equal(2, mem.Len())
+
+	_, ok = mem.Get(`b`)
+	assert.False(ok)
+
+	_, ok = mem.Get(`a`)
+	assert.True(ok)
+}
+
+func TestDiskCache(t *testing.T) {
+	var assert = require.New(t)
+	var dir = t.TempDir()
+	var disk, err = NewDiskCache(dir, 2)
+	assert.NoError(err)
+
+	var _, ok = disk.Get(`missing`)
+	assert.False(ok)
+
+	var expires = time.Now().Add(time.Minute).Round(time.Second)
+
+	assert.NoError(disk.Set(`key`, &CacheEntry{
+		Value:     map[string]any{`hello`: `there`},
+		ExpiresAt: expires,
+	}))
+
+	var entry *CacheEntry
+	entry, ok = disk.Get(`key`)
+	assert.True(ok)
+	assert.Equal(map[string]any{`hello`: `there`}, entry.Value)
+	assert.True(expires.Equal(entry.ExpiresAt))
+
+	// the least recently used entries are removed once there are too many
+	assert.NoError(disk.Set(`other`, &CacheEntry{Value: 2}))
+
+	var past = time.Now().Add(-time.Hour)
+	assert.NoError(os.Chtimes(disk.filename(`key`), past, past))
+	assert.NoError(os.Chtimes(disk.filename(`other`), past.Add(time.Minute), past.Add(time.Minute)))
+
+	_, ok = disk.Get(`key`)
+	assert.True(ok)
+
+	assert.NoError(disk.Set(`newest`, &CacheEntry{Value: 3}))
+
+	var files, _ = filepath.Glob(filepath.Join(dir, `*`))
+	assert.Len(files, 2)
+
+	_, ok = disk.Get(`other`)
+	assert.False(ok)
+
+	for _, key := range []string{`key`, `newest`} {
+		_, ok = disk.Get(key)
+		assert.True(ok, key)
+	}
+}
+
+func TestCacheConfigValidation(t *testing.T) {
+	var assert = require.New(t)
+
+	for _, cache := range []*CacheConfig{
+		{},
+		{TTL: `soon`},
+		{TTL: `-1m`},
+		{TTL: `1m`, Stale: `later`},
+	} {
+		var datasets = NewConfig().Datasets
+		datasets.Endpoints[`bad-cache`] = &Endpoint{URL: `http://127.0.0.1`, Cache: cache}
+
+		var err = loadDatasets(datasets)
+		assert.Error(err)
+		assert.Contains(err.Error(), `endpoint bad-cache: cache:`)
+
+		_, err = NewQueryOptions().Query(&Endpoint{URL: `http://127.0.0.1`, Cache: cache})
+		assert.Error(err)
+		assert.Contains(err.Error(), `must be a positive duration`)
+	}
+}
+
+func TestCacheKeyIncludesPaginationVars(t *testing.T) {
+	var assert = require.New(t)
+	var calls int32
+
+	var server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&calls, 1)
+		httputil.RespondJSON(w, map[string]any{
+			`items`: []any{
+				map[string]any{`kind`: `a`, `n`: 1},
+				map[string]any{`kind`: `b`, `n`: 2},
+			},
+		})
+	}))
+	defer server.Close()
+
+	var endpoint = &Endpoint{
+		Name: `cached-pages`,
+		URL:  server.URL,
+		Cache: &CacheConfig{
+			TTL: `1m`,
+		},
+		Pagination: &Pagination{
+			Style:       CursorPagination,
+			CursorQuery: `next`,
+			ItemsQuery:  `items[kind = $kind].n`,
+		},
+	}
+
+	for i, kind := range []string{`a`, `b`, `a`} {
+		var opts = NewQueryOptions()
+		opts.Variables = map[string]any{`kind`: kind}
+
+		var response, err = opts.Query(endpoint)
+		assert.NoError(err)
+		assert.Equal([]any{float64(i%2 + 1)}, response.Result)
+	}
+
+	assert.EqualValues(2, atomic.LoadInt32(&calls))
+}
@@ -0,0 +1,89 @@
+package orchestra
+
+import (
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/ghetzel/go-stockutil/httputil"
+)
+
+// ConditionalResponses holds the validators and decoded bodies of previous responses, keyed by
+// rendered request, so that repeated requests can be made conditional.
+var ConditionalResponses CacheBackend = NewMemoryCache(DefaultCacheMaxEntries)
+
+type conditionalEntry struct {
+	ETag         string
+	LastModified string
+	Body         any
+}
+
+// conditional reports whether requests made to the endpoint with the given method should carry
+// validators from earlier responses.
+func (endpoint *Endpoint) conditional(method httputil.Method) bool {
+	if endpoint.NoConditional || ConditionalResponses == nil {
+		return false
+	}
+
+	switch method {
+	case httputil.Get, httputil.Head:
+		return true
+	default:
+		return false
+	}
+}
+
+func lookupConditional(key string) (*conditionalEntry, bool) {
+	if entry, ok := ConditionalResponses.Get(key); ok {
+		if cond, ok := entry.Value.(*conditionalEntry); ok {
+			return cond, true
+		}
+	}
+
+	return nil, false
+}
+
+// withValidators returns a copy of headers with If-None-Match and If-Modified-Since set from the
+// last response to the request identified by key.  Explicitly provided values are left alone.
+func withValidators(key string, headers map[string]any) map[string]any {
+	var out = make(map[string]any)
+
+	for k, v := range headers {
+		out[k] = v
+	}
+
+	if cond, ok := lookupConditional(key); ok {
+		for k := range out {
+			switch strings.ToLower(k) {
+			case `if-none-match`, `if-modified-since`:
+				return out
+			}
+		}
+
+		if cond.ETag != `` {
+			out[`If-None-Match`] = cond.ETag
+		}
+
+		if cond.LastModified != `` {
+			out[`If-Modified-Since`] = cond.LastModified
+		}
+	}
+
+	return out
+}
+
+// rememberValidators stores the validators and decoded body of response, if it carries any.
+func rememberValidators(key string, response *http.Response, body any) {
+	var cond = &conditionalEntry{
+		ETag:         response.Header.Get(`ETag`),
+		LastModified: response.Header.Get(`Last-Modified`),
+		Body:         body,
+	}
+
+	if cond.ETag != `` || cond.LastModified != `` {
+		ConditionalResponses.Set(key, &CacheEntry{
+			Value:    cond,
+			StoredAt: time.Now(),
+		})
+	}
+}
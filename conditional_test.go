@@ -0,0 +1,70 @@
+package orchestra
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+
+	"github.com/ghetzel/go-stockutil/httputil"
+	"github.com/ghetzel/testify/require"
+)
+
+func TestConditionalRequests(t *testing.T) {
+	var assert = require.New(t)
+	var full, notModified int32
+
+	var server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var etag = `"v-` + r.URL.Query().Get(`q`) + `"`
+
+		if r.Header.Get(`If-None-Match`) == etag {
+			atomic.AddInt32(&notModified, 1)
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+
+		atomic.AddInt32(&full, 1)
+		w.Header().Set(`ETag`, etag)
+		httputil.RespondJSON(w, map[string]any{
+			`q`: r.URL.Query().Get(`q`),
+		})
+	}))
+	defer server.Close()
+
+	var endpoint = &Endpoint{
+		Name: `conditional`,
+		URL:  server.URL,
+		ResultFilters: []any{
+			`q`,
+		},
+	}
+
+	var query = func(q string) *QueryResponse {
+		var opts = NewQueryOptions()
+		opts.Params[`q`] = q
+
+		var response, err = opts.Query(endpoint)
+		assert.NoError(err)
+		return response
+	}
+
+	var res = query(`a`)
+	assert.Equal(`a`, res.Result)
+	assert.Nil(res.Context[`not_modified`])
+
+	res = query(`a`)
+	assert.Equal(`a`, res.Result)
+	assert.Equal(1, res.Context[`not_modified`])
+
+	res = query(`b`)
+	assert.Equal(`b`, res.Result)
+
+	assert.EqualValues(2, atomic.LoadInt32(&full))
+	assert.EqualValues(1, atomic.LoadInt32(&notModified))
+
+	endpoint.NoConditional = true
+
+	res = query(`a`)
+	assert.Equal(`a`, res.Result)
+	assert.EqualValues(3, atomic.LoadInt32(&full))
+}
@@ -1,17 +1,21 @@
 package orchestra
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"io"
 	"io/fs"
 	"os"
 	"path/filepath"
+	"slices"
+	"strings"
 
 	"gopkg.in/yaml.v3"
 
 	"github.com/ghetzel/go-stockutil/fileutil"
 	"github.com/ghetzel/go-stockutil/log"
+	"github.com/ghetzel/go-stockutil/maputil"
 	"github.com/ghetzel/go-stockutil/stringutil"
 )
 
@@ -56,21 +60,96 @@ var ManagedConfigDir string = func() string {
 }()
 
 type DatasetConfig struct {
-	Endpoints map[string]*Endpoint `yaml:"endpoints" json:"endpoints"`
-	Queries   map[string]*Schema   `yaml:"queries"   json:"queries"`
+	Endpoints map[string]*Endpoint   `yaml:"endpoints"      json:"endpoints"`
+	Queries   map[string]*Schema     `yaml:"queries"        json:"queries"`
+	Auth      map[string]*AuthConfig `yaml:"auth,omitempty" json:"auth,omitempty"`
 }
 
 func (dataset *DatasetConfig) QuerySchema(name string, query *QueryOptions) (*QueryResponse, error) {
+	return dataset.QuerySchemaWithContext(context.Background(), name, query)
+}
+
+func (dataset *DatasetConfig) QuerySchemaWithContext(ctx context.Context, name string, query *QueryOptions) (*QueryResponse, error) {
 	if schema, ok := dataset.Queries[name]; ok {
-		return schema.Query(query)
+		if schemaCtx, err := enterSchema(ctx, dataset, name); err == nil {
+			return schema.QueryWithContext(schemaCtx, query)
+		} else {
+			return nil, err
+		}
 	} else {
 		return nil, fmt.Errorf("undefined schema %q", name)
 	}
 }
 
+// validateSchemaCalls makes sure every query called from a pipeline step exists, and that no query
+// ends up calling itself.
+func (dataset *DatasetConfig) validateSchemaCalls() error {
+	var calls = make(map[string][]string)
+
+	for name, query := range dataset.Queries {
+		if pipeline := query.Pipeline; pipeline != nil {
+			if err := validateTimeout(pipeline.Timeout); err != nil {
+				return fmt.Errorf("query %v: %v", name, err)
+			}
+
+			for i, step := range pipeline.Steps {
+				if err := validateTimeout(step.Timeout); err != nil {
+					return fmt.Errorf("query %v, step %v: %v", name, i, err)
+				}
+
+				if stepq := step.Query; stepq != nil && stepq.Schema != `` {
+					if stepq.UseEndpoint != `` {
+						return fmt.Errorf("query %v, step %v: cannot use both endpoint and schema", name, i)
+					} else if s, ok := dataset.Queries[stepq.Schema]; !ok || s == nil {
+						return fmt.Errorf("query %v, step %v: undefined schema %q", name, i, stepq.Schema)
+					}
+
+					calls[name] = append(calls[name], stepq.Schema)
+				}
+			}
+		}
+	}
+
+	var state = make(map[string]int)
+	var visit func(chain []string) error
+
+	visit = func(chain []string) error {
+		var name = chain[len(chain)-1]
+
+		switch state[name] {
+		case 1:
+			return fmt.Errorf("query cycle: %s", strings.Join(chain, ` -> `))
+		case 2:
+			return nil
+		}
+
+		state[name] = 1
+
+		for _, callee := range calls[name] {
+			if err := visit(append(slices.Clone(chain), callee)); err != nil {
+				return err
+			}
+		}
+
+		state[name] = 2
+		return nil
+	}
+
+	for _, name := range maputil.StringKeys(calls) {
+		if err := visit([]string{name}); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
 type Config struct {
-	ServerAddress string         `yaml:"address,omitempty" json:"address,omitempty"`
-	Datasets      *DatasetConfig `yaml:"datasets"          json:"datasets"`
+	ServerAddress string         `yaml:"address,omitempty"        json:"address,omitempty"`
+	Concurrency   int            `yaml:"concurrency,omitempty"    json:"concurrency,omitempty"`
+	MaxWorkers    int            `yaml:"max_workers,omitempty"    json:"max_workers,omitempty"`
+	SensitiveKeys []string       `yaml:"sensitive_keys,omitempty" json:"sensitive_keys,omitempty"`
+	Datasets      *DatasetConfig `yaml:"datasets"                 json:"datasets"`
 }
 
 var DefaultConfig *Config
@@ -80,6 +159,7 @@ func NewConfig() *Config {
 		Datasets: &DatasetConfig{
 			Endpoints: make(map[string]*Endpoint),
 			Queries:   make(map[string]*Schema),
+			Auth:      make(map[string]*AuthConfig),
 		},
 	}
 }
@@ -125,6 +205,14 @@ func loadDatasets(base *DatasetConfig, datasetDirs ...string) error {
 								for k, v := range subset.Queries {
 									base.Queries[k] = v
 								}
+
+								for k, v := range subset.Auth {
+									if base.Auth == nil {
+										base.Auth = make(map[string]*AuthConfig)
+									}
+
+									base.Auth[k] = v
+								}
 							} else {
 								log.Errorf("datasets %v: %v", d.Name(), err)
 							}
@@ -141,7 +229,44 @@ func loadDatasets(base *DatasetConfig, datasetDirs ...string) error {
 		}
 	}
 
+	for name, auth := range base.Auth {
+		if auth.Profile != `` {
+			return fmt.Errorf("auth profile %v cannot refer to another profile", name)
+		}
+
+		RegisterAuthProfile(name, auth)
+	}
+
 	for name, endpoint := range base.Endpoints {
+		if auth := endpoint.Auth; auth != nil && auth.Profile != `` {
+			if p, ok := base.Auth[auth.Profile]; !ok || p == nil {
+				return fmt.Errorf("endpoint %v: undefined auth profile %q", name, auth.Profile)
+			}
+		}
+
+		// headers and params marked as sensitive are masked from the outset
+		for _, fields := range []map[string]any{endpoint.Headers, endpoint.Params} {
+			for k, v := range fields {
+				unwrapSensitive(k, v, nil)
+			}
+		}
+
+		if err := validateTimeout(endpoint.Timeout); err != nil {
+			return fmt.Errorf("endpoint %v: %v", name, err)
+		}
+
+		if cache := endpoint.Cache; cache != nil {
+			if err := cache.validate(); err != nil {
+				return fmt.Errorf("endpoint %v: %v", name, err)
+			}
+		}
+
+		if pager := endpoint.Pagination; pager != nil {
+			if err := pager.validate(); err != nil {
+				return fmt.Errorf("endpoint %v: %v", name, err)
+			}
+		}
+
 		endpoint.Name = name
 		RegisterEndpoint(name, endpoint)
 	}
@@ -159,9 +284,17 @@ func loadDatasets(base *DatasetConfig, datasetDirs ...string) error {
 					}
 				}
 			}
+
+			if _, err := pipeline.dependencies(); err != nil {
+				return fmt.Errorf("query %v: %v", name, err)
+			}
 		}
 	}
 
+	if err := base.validateSchemaCalls(); err != nil {
+		return err
+	}
+
 	return nil
 }
 
@@ -175,6 +308,18 @@ func LoadDefaultConfig() error {
 			return err
 		}
 
+		if DefaultConfig.Concurrency > 0 {
+			MaxConcurrency = DefaultConfig.Concurrency
+		}
+
+		if DefaultConfig.MaxWorkers > 0 {
+			MaxWorkers = DefaultConfig.MaxWorkers
+		}
+
+		if len(DefaultConfig.SensitiveKeys) > 0 {
+			SensitiveKeys = DefaultConfig.SensitiveKeys
+		}
+
 		if err := loadDatasets(DefaultConfig.Datasets, DatasetsPath...); err != nil {
 			return err
 		}
@@ -0,0 +1,300 @@
+package orchestra
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+type ResponseFormat string
+
+const (
+	AutoFormat   ResponseFormat = `auto`
+	JSONFormat   ResponseFormat = `json`
+	XMLFormat    ResponseFormat = `xml`
+	CSVFormat    ResponseFormat = `csv`
+	TSVFormat    ResponseFormat = `tsv`
+	YAMLFormat   ResponseFormat = `yaml`
+	NDJSONFormat ResponseFormat = `ndjson`
+	TextFormat   ResponseFormat = `text`
+	LinesFormat  ResponseFormat = `lines`
+	HTMLFormat   ResponseFormat = `html`
+)
+
+// ResponseDecoderFunc decodes a response body into native values.
+type ResponseDecoderFunc func(r io.Reader) (any, error)
+
+// ResponseDecoders maps each response format to the function used to decode it.
+var ResponseDecoders = map[ResponseFormat]ResponseDecoderFunc{
+	JSONFormat:   decodeJSON,
+	XMLFormat:    decodeXML,
+	CSVFormat:    delimitedDecoder(','),
+	TSVFormat:    delimitedDecoder('\t'),
+	YAMLFormat:   decodeYAML,
+	NDJSONFormat: decodeNDJSON,
+	TextFormat:   decodeText,
+	LinesFormat:  decodeLines,
+	HTMLFormat:   decodeText,
+}
+
+// FormatForContentType guesses the response format from a Content-Type header value, defaulting
+// to JSON.
+func FormatForContentType(contentType string) ResponseFormat {
+	var mediaType, _, _ = mime.ParseMediaType(contentType)
+
+	switch mediaType {
+	case `text/csv`, `application/csv`:
+		return CSVFormat
+	case `text/tab-separated-values`:
+		return TSVFormat
+	case `application/yaml`, `application/x-yaml`, `text/yaml`, `text/x-yaml`:
+		return YAMLFormat
+	case `application/x-ndjson`, `application/ndjson`, `application/jsonl`, `application/x-jsonlines`:
+		return NDJSONFormat
+	case `text/html`, `application/xhtml+xml`:
+		return HTMLFormat
+	case `application/xml`, `text/xml`:
+		return XMLFormat
+	}
+
+	if strings.HasSuffix(mediaType, `+json`) {
+		return JSONFormat
+	} else if strings.HasSuffix(mediaType, `+xml`) {
+		return XMLFormat
+	} else if strings.HasPrefix(mediaType, `text/`) {
+		return TextFormat
+	} else {
+		return JSONFormat
+	}
+}
+
+// decodeResponse decodes (and closes) the body of response according to format.  Responses are
+// decoded as JSON unless another format is given; the auto format picks one from the Content-Type.
+func decodeResponse(format ResponseFormat, response *http.Response) (any, error) {
+	defer response.Body.Close()
+
+	if format == `` {
+		format = JSONFormat
+	} else if format == AutoFormat {
+		format = FormatForContentType(response.Header.Get(`Content-Type`))
+	}
+
+	return decodeAs(format, response.Body)
+}
+
+// decode decodes (and closes) the response body, extracting data from it as HTML if the endpoint
+// has scrape selectors.
+func (endpoint *Endpoint) decode(response *http.Response) (any, error) {
+	if len(endpoint.Scrape) > 0 {
+		defer response.Body.Close()
+
+		if out, err := scrapeHTML(response.Body, endpoint.Scrape); err == nil {
+			return out, nil
+		} else {
+			return nil, fmt.Errorf("scrape: %v", err)
+		}
+	}
+
+	return decodeResponse(endpoint.ResponseFormat, response)
+}
+
+func decodeAs(format ResponseFormat, r io.Reader) (any, error) {
+	if decoder, ok := ResponseDecoders[format]; ok && decoder != nil {
+		if out, err := decoder(r); err == nil {
+			return out, nil
+		} else {
+			return nil, fmt.Errorf("decode %s: %v", format, err)
+		}
+	} else {
+		return nil, fmt.Errorf("unsupported response format %q", format)
+	}
+}
+
+func decodeJSON(r io.Reader) (any, error) {
+	var out any
+	var err = json.NewDecoder(r).Decode(&out)
+
+	return out, err
+}
+
+func decodeYAML(r io.Reader) (any, error) {
+	var out any
+
+	if err := yaml.NewDecoder(r).Decode(&out); err == nil || errors.Is(err, io.EOF) {
+		return out, nil
+	} else {
+		return nil, err
+	}
+}
+
+func decodeText(r io.Reader) (any, error) {
+	if data, err := io.ReadAll(r); err == nil {
+		return string(data), nil
+	} else {
+		return nil, err
+	}
+}
+
+// decodeLines returns each non-blank line of text, with surrounding whitespace removed.
+func decodeLines(r io.Reader) (any, error) {
+	var out = make([]any, 0)
+	var scanner = bufio.NewScanner(r)
+
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+
+	for scanner.Scan() {
+		if line := strings.TrimSpace(scanner.Text()); line != `` {
+			out = append(out, line)
+		}
+	}
+
+	return out, scanner.Err()
+}
+
+// decodeNDJSON decodes one JSON value per line, skipping blank lines.
+func decodeNDJSON(r io.Reader) (any, error) {
+	var out = make([]any, 0)
+	var scanner = bufio.NewScanner(r)
+
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+
+	for line := 1; scanner.Scan(); line++ {
+		var text = bytes.TrimSpace(scanner.Bytes())
+		var value any
+
+		if len(text) == 0 {
+			continue
+		} else if err := json.Unmarshal(text, &value); err == nil {
+			out = append(out, value)
+		} else {
+			return nil, fmt.Errorf("line %d: %v", line, err)
+		}
+	}
+
+	return out, scanner.Err()
+}
+
+// delimitedDecoder returns a decoder that maps each row after the header row to an object keyed on
+// the header's column names.
+func delimitedDecoder(delimiter rune) ResponseDecoderFunc {
+	return func(r io.Reader) (any, error) {
+		var reader = csv.NewReader(r)
+		var out = make([]any, 0)
+		var header []string
+
+		reader.Comma = delimiter
+		reader.FieldsPerRecord = -1
+
+		for {
+			var record, err = reader.Read()
+
+			if errors.Is(err, io.EOF) {
+				break
+			} else if err != nil {
+				return nil, err
+			}
+
+			if header == nil {
+				header = record
+				continue
+			}
+
+			var row = make(map[string]any)
+
+			for i, column := range header {
+				if i < len(record) {
+					row[column] = record[i]
+				} else {
+					row[column] = nil
+				}
+			}
+
+			out = append(out, row)
+		}
+
+		return out, nil
+	}
+}
+
+// decodeXML converts an XML document into native values.  Each element becomes an object keyed on
+// its child element names (repeated children become lists), with attributes prefixed by "@" and any
+// text content under "#text".  Elements with neither attributes nor children become plain strings.
+func decodeXML(r io.Reader) (any, error) {
+	var decoder = xml.NewDecoder(r)
+
+	for {
+		var token, err = decoder.Token()
+
+		if err != nil {
+			return nil, err
+		}
+
+		if start, ok := token.(xml.StartElement); ok {
+			if value, err := decodeXMLElement(decoder, start); err == nil {
+				return map[string]any{
+					start.Name.Local: value,
+				}, nil
+			} else {
+				return nil, err
+			}
+		}
+	}
+}
+
+func decodeXMLElement(decoder *xml.Decoder, start xml.StartElement) (any, error) {
+	var element = make(map[string]any)
+	var text strings.Builder
+
+	for _, attr := range start.Attr {
+		element[`@`+attr.Name.Local] = attr.Value
+	}
+
+	for {
+		var token, err = decoder.Token()
+
+		if err != nil {
+			return nil, err
+		}
+
+		switch t := token.(type) {
+		case xml.StartElement:
+			if child, err := decodeXMLElement(decoder, t); err == nil {
+				var name = t.Name.Local
+
+				if existing, ok := element[name]; ok {
+					if list, ok := existing.([]any); ok {
+						element[name] = append(list, child)
+					} else {
+						element[name] = []any{existing, child}
+					}
+				} else {
+					element[name] = child
+				}
+			} else {
+				return nil, err
+			}
+		case xml.CharData:
+			text.Write(t)
+		case xml.EndElement:
+			var content = strings.TrimSpace(text.String())
+
+			if len(element) == 0 {
+				return content, nil
+			} else if content != `` {
+				element[`#text`] = content
+			}
+
+			return element, nil
+		}
+	}
+}
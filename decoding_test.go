@@ -0,0 +1,112 @@
+package orchestra
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/ghetzel/testify/require"
+)
+
+func TestResponseFormats(t *testing.T) {
+	var assert = require.New(t)
+
+	var server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case `/feed.xml`:
+			w.Header().Set(`Content-Type`, `application/rss+xml`)
+			w.Write([]byte(`<rss version="2.0"><channel><title>News</title><item><title>one</title></item><item><title>two</title></item></channel></rss>`))
+		case `/people.csv`:
+			w.Header().Set(`Content-Type`, `text/csv`)
+			w.Write([]byte("name,age\nalice,30\nbob,41\n"))
+		case `/people.tsv`:
+			w.Write([]byte("name\tage\nalice\t30\n"))
+		case `/config.yaml`:
+			w.Header().Set(`Content-Type`, `application/yaml`)
+			w.Write([]byte("name: test\ntags: [a, b]\n"))
+		case `/events`:
+			w.Header().Set(`Content-Type`, `application/x-ndjson`)
+			w.Write([]byte("{\"id\":1}\n\n{\"id\":2}\n"))
+		case `/plain.json`:
+			w.Header().Set(`Content-Type`, `text/plain; charset=utf-8`)
+			w.Write([]byte(`{"ok": true}`))
+		case `/page`:
+			w.Header().Set(`Content-Type`, `text/html; charset=utf-8`)
+			w.Write([]byte(`<p>hi</p>`))
+		default:
+			w.Header().Set(`Content-Type`, `text/plain`)
+			w.Write([]byte(`hello`))
+		}
+	}))
+	defer server.Close()
+
+	var query = func(path string, format ResponseFormat) any {
+		var response, err = NewQueryOptions().Query(&Endpoint{
+			URL:            server.URL + path,
+			ResponseFormat: format,
+		})
+
+		assert.NoError(err)
+		return response.Result
+	}
+
+	assert.Equal(map[string]any{
+		`rss`: map[string]any{
+			`@version`: `2.0`,
+			`channel`: map[string]any{
+				`title`: `News`,
+				`item`: []any{
+					map[string]any{`title`: `one`},
+					map[string]any{`title`: `two`},
+				},
+			},
+		},
+	}, query(`/feed.xml`, AutoFormat))
+
+	assert.Equal([]any{
+		map[string]any{`name`: `alice`, `age`: `30`},
+		map[string]any{`name`: `bob`, `age`: `41`},
+	}, query(`/people.csv`, AutoFormat))
+
+	assert.Equal([]any{
+		map[string]any{`name`: `alice`, `age`: `30`},
+	}, query(`/people.tsv`, TSVFormat))
+
+	assert.Equal(map[string]any{
+		`name`: `test`,
+		`tags`: []any{`a`, `b`},
+	}, query(`/config.yaml`, AutoFormat))
+
+	assert.Equal([]any{
+		map[string]any{`id`: float64(1)},
+		map[string]any{`id`: float64(2)},
+	}, query(`/events`, AutoFormat))
+
+	assert.Equal(`<p>hi</p>`, query(`/page`, AutoFormat))
+	assert.Equal(`hello`, query(`/text`, AutoFormat))
+
+	// without a format, responses are decoded as JSON whatever their Content-Type
+	assert.Equal(map[string]any{`ok`: true}, query(`/plain.json`, ``))
+	assert.Equal(map[string]any{`ok`: true}, query(`/plain.json`, JSONFormat))
+
+	var _, err = NewQueryOptions().Query(&Endpoint{
+		URL:            server.URL + `/text`,
+		ResponseFormat: `bogus`,
+	})
+
+	assert.Error(err)
+	assert.Contains(err.Error(), `unsupported response format`)
+}
+
+func TestFormatForContentType(t *testing.T) {
+	var assert = require.New(t)
+
+	assert.Equal(JSONFormat, FormatForContentType(`application/json`))
+	assert.Equal(JSONFormat, FormatForContentType(`application/vnd.api+json`))
+	assert.Equal(JSONFormat, FormatForContentType(``))
+	assert.Equal(XMLFormat, FormatForContentType(`text/xml; charset=utf-8`))
+	assert.Equal(CSVFormat, FormatForContentType(`text/csv`))
+	assert.Equal(YAMLFormat, FormatForContentType(`application/x-yaml`))
+	assert.Equal(HTMLFormat, FormatForContentType(`text/html`))
+	assert.Equal(TextFormat, FormatForContentType(`text/plain`))
+}
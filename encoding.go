@@ -0,0 +1,154 @@
+package orchestra
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"path/filepath"
+	"strings"
+
+	"github.com/ghetzel/go-stockutil/httputil"
+	"github.com/ghetzel/go-stockutil/maputil"
+	"github.com/ghetzel/go-stockutil/sliceutil"
+	"github.com/ghetzel/go-stockutil/typeutil"
+)
+
+type BodyEncoding string
+
+const (
+	JSONBody      BodyEncoding = `json`
+	FormBody      BodyEncoding = `form`
+	MultipartBody BodyEncoding = `multipart`
+	RawBody       BodyEncoding = `raw`
+)
+
+// bodyEncoder returns the httputil encoder used to serialize request bodies for the given encoding.
+func bodyEncoder(encoding BodyEncoding) (httputil.EncoderFunc, error) {
+	switch encoding {
+	case ``, JSONBody:
+		return httputil.JSONEncoder, nil
+	case FormBody:
+		return FormEncoder, nil
+	case MultipartBody:
+		return MultipartEncoder, nil
+	case RawBody:
+		return RawEncoder, nil
+	default:
+		return nil, fmt.Errorf("unsupported body encoding %q", encoding)
+	}
+}
+
+// FormEncoder encodes a map as an application/x-www-form-urlencoded request body.  List values
+// are sent as repeated fields, and nested objects are sent as JSON.
+func FormEncoder(in any) (io.Reader, error) {
+	if req, ok := in.(*http.Request); ok {
+		req.Header.Set(`Content-Type`, `application/x-www-form-urlencoded`)
+		return nil, nil
+	} else if typeutil.IsMap(in) {
+		var form = make(url.Values)
+
+		for k, v := range maputil.M(in).MapNative() {
+			if typeutil.IsArray(v) {
+				for _, item := range sliceutil.Sliceify(v) {
+					form.Add(k, formValue(item))
+				}
+			} else {
+				form.Set(k, formValue(v))
+			}
+		}
+
+		return strings.NewReader(form.Encode()), nil
+	} else if s, ok := in.(string); ok {
+		return strings.NewReader(s), nil
+	} else {
+		return nil, fmt.Errorf("form body must be an object, got %T", in)
+	}
+}
+
+func formValue(v any) string {
+	if typeutil.IsMap(v) {
+		return compactJSON(v)
+	} else {
+		return typeutil.String(v)
+	}
+}
+
+func compactJSON(v any) string {
+	if data, err := json.Marshal(v); err == nil {
+		return string(data)
+	} else {
+		return typeutil.String(v)
+	}
+}
+
+// MultipartEncoder encodes a map as a multipart/form-data request body.  A field whose value is an
+// object with a "file" key is sent as a file part read from that path, which is resolved in the same
+// way as (and confined to the same directories as) file:// endpoints; an object with
+// "filename" and "content" keys is sent as a file part with the given content (e.g.: the output of
+// a previous step).  All other values are sent as plain fields.
+func MultipartEncoder(in any) (io.Reader, error) {
+	if _, ok := in.(*http.Request); ok || !typeutil.IsMap(in) {
+		return httputil.MultipartFormEncoder(in)
+	}
+
+	var fields = make(map[string]any)
+
+	for k, v := range maputil.M(in).MapNative() {
+		if typeutil.IsMap(v) {
+			var part = maputil.M(v)
+
+			if path := part.String(`file`); path != `` {
+				if data, err := readRequestFile(path); err == nil {
+					fields[k] = &httputil.MultipartFormFile{
+						Filename: part.String(`filename`, filepath.Base(path)),
+						Data:     bytes.NewReader(data),
+					}
+				} else {
+					return nil, fmt.Errorf("multipart field %q: %v", k, err)
+				}
+
+				continue
+			} else if filename := part.String(`filename`); filename != `` {
+				var content = part.Get(`content`).Value
+				var data []byte
+
+				if b, ok := content.([]byte); ok {
+					data = b
+				} else if typeutil.IsMap(content) || typeutil.IsArray(content) {
+					data = []byte(compactJSON(content))
+				} else {
+					data = []byte(typeutil.String(content))
+				}
+
+				fields[k] = &httputil.MultipartFormFile{
+					Filename: filename,
+					Data:     bytes.NewReader(data),
+				}
+
+				continue
+			}
+
+			fields[k] = compactJSON(v)
+		} else {
+			fields[k] = v
+		}
+	}
+
+	return httputil.MultipartFormEncoder(fields)
+}
+
+// RawEncoder sends the body as-is, converting it to a string if necessary.
+func RawEncoder(in any) (io.Reader, error) {
+	if _, ok := in.(*http.Request); ok {
+		return nil, nil
+	} else if b, ok := in.([]byte); ok {
+		return bytes.NewReader(b), nil
+	} else if r, ok := in.(io.Reader); ok {
+		return r, nil
+	} else {
+		return strings.NewReader(typeutil.String(in)), nil
+	}
+}
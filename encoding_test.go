@@ -0,0 +1,142 @@
+package orchestra
+
+import (
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/ghetzel/go-stockutil/maputil"
+	"github.com/ghetzel/testify/require"
+)
+
+func TestFormBodyEncoding(t *testing.T) {
+	var assert = require.New(t)
+
+	var response, err = NewQueryOptions().Query(&Endpoint{
+		Method:       `POST`,
+		URL:          TestEchoServer.URL,
+		BodyEncoding: FormBody,
+		RequestBody: map[string]any{
+			`name`: `{{ .vars.name }}`,
+			`tags`: []any{`a`, `b`},
+		},
+		Variables: map[string]any{
+			`name`: `orchestra & co`,
+		},
+	})
+
+	assert.NoError(err)
+
+	var echo = maputil.M(response.Result)
+	assert.Equal(`application/x-www-form-urlencoded`, echo.String(`content_type`))
+
+	var form, perr = url.ParseQuery(echo.String(`body`))
+	assert.NoError(perr)
+	assert.Equal(`orchestra & co`, form.Get(`name`))
+	assert.Equal([]string{`a`, `b`}, form[`tags`])
+}
+
+func TestMultipartBodyEncoding(t *testing.T) {
+	var assert = require.New(t)
+	var dir = t.TempDir()
+	var upload = filepath.Join(dir, `upload.txt`)
+
+	assert.NoError(os.WriteFile(upload, []byte(`from disk`), 0600))
+
+	var saved = DatasetsPath
+	DatasetsPath = []string{dir}
+	defer func() { DatasetsPath = saved }()
+
+	var response, err = NewQueryOptions().Query(&Endpoint{
+		Method:       `POST`,
+		URL:          TestEchoServer.URL,
+		BodyEncoding: MultipartBody,
+		RequestBody: map[string]any{
+			`title`: `hello`,
+			`disk`: map[string]any{
+				`file`: upload,
+			},
+			`generated`: map[string]any{
+				`filename`: `data.json`,
+				`content`:  map[string]any{`ok`: true},
+			},
+		},
+	})
+
+	assert.NoError(err)
+
+	var echo = maputil.M(response.Result)
+	var mediaType, params, merr = mime.ParseMediaType(echo.String(`content_type`))
+	assert.NoError(merr)
+	assert.Equal(`multipart/form-data`, mediaType)
+
+	var reader = multipart.NewReader(strings.NewReader(echo.String(`body`)), params[`boundary`])
+	var parts = make(map[string][2]string)
+
+	for {
+		var part, err = reader.NextPart()
+
+		if err == io.EOF {
+			break
+		}
+
+		assert.NoError(err)
+
+		var data, _ = io.ReadAll(part)
+		parts[part.FormName()] = [2]string{part.FileName(), string(data)}
+	}
+
+	assert.Equal([2]string{``, `hello`}, parts[`title`])
+	assert.Equal([2]string{`upload.txt`, `from disk`}, parts[`disk`])
+	assert.Equal([2]string{`data.json`, `{"ok":true}`}, parts[`generated`])
+
+	// files are read relative to the datasets path, which they can't lead outside of
+	for file, msg := range map[string]string{
+		`upload.txt`:    ``,
+		`../upload.txt`: `is outside of the datasets path`,
+		`/etc/passwd`:   `is outside of the datasets path`,
+	} {
+		var opts = NewQueryOptions()
+		opts.Body = map[string]any{
+			`disk`: map[string]any{`file`: file},
+		}
+
+		_, err = opts.Query(&Endpoint{
+			Method:       `POST`,
+			URL:          TestEchoServer.URL,
+			BodyEncoding: MultipartBody,
+		})
+
+		if msg == `` {
+			assert.NoError(err, file)
+		} else {
+			assert.Error(err, file)
+			assert.Contains(err.Error(), msg, file)
+		}
+	}
+}
+
+func TestRawBodyEncoding(t *testing.T) {
+	var assert = require.New(t)
+
+	var response, err = NewQueryOptions().Query(&Endpoint{
+		Method:       `PUT`,
+		URL:          TestEchoServer.URL,
+		BodyEncoding: RawBody,
+		RequestBody:  `plain {{ .vars.x }}`,
+		Variables: map[string]any{
+			`x`: `text`,
+		},
+	})
+
+	assert.NoError(err)
+	assert.Equal(`plain text`, maputil.M(response.Result).String(`body`))
+
+	_, err = bodyEncoder(`bogus`)
+	assert.Error(err)
+}
@@ -32,15 +32,31 @@ func (kind DataKind) String() string {
 }
 
 type Endpoint struct {
-	Name          string         `yaml:"name,omitempty"        json:"name,omitempty"`
-	Method        string         `yaml:"method,omitempty"      json:"method,omitempty"`
-	URL           string         `yaml:"url"                   json:"url"`
-	RequestBody   any            `yaml:"body,omitempty"        json:"body,omitempty"`
-	GraphQL       *GraphQLQuery  `yaml:"graphql,omitempty"     json:"graphql,omitempty"`
-	PathParams    map[string]any `yaml:"path_params,omitempty" json:"path_params,omitempty"`
-	Params        map[string]any `yaml:"params,omitempty"      json:"params,omitempty"`
-	Headers       map[string]any `yaml:"headers,omitempty"     json:"headers,omitempty"`
-	ResultType    DataKind       `yaml:"type,omitempty"        json:"type,omitempty"`
-	ResultFilters []any          `yaml:"filters,omitempty"     json:"filters,omitempty"`
-	Variables     map[string]any `yaml:"variables,omitempty"   json:"variables,omitempty"`
+	Name             string         `yaml:"name,omitempty"            json:"name,omitempty"`
+	Method           string         `yaml:"method,omitempty"          json:"method,omitempty"`
+	Kind             string         `yaml:"kind,omitempty"            json:"kind,omitempty"`
+	URL              string         `yaml:"url"                       json:"url"`
+	RequestBody      any            `yaml:"body,omitempty"            json:"body,omitempty"`
+	RequestBodyQuery any            `yaml:"body_json,omitempty"       json:"body_json,omitempty"`
+	BodyEncoding     BodyEncoding   `yaml:"body_encoding,omitempty"   json:"body_encoding,omitempty"`
+	SQL              *SQLConfig     `yaml:"sql,omitempty"             json:"sql,omitempty"`
+	Exec             *ExecConfig    `yaml:"exec,omitempty"            json:"exec,omitempty"`
+	GRPC             *GRPCConfig    `yaml:"grpc,omitempty"            json:"grpc,omitempty"`
+	Stream           *StreamConfig  `yaml:"stream,omitempty"          json:"stream,omitempty"`
+	GraphQL          *GraphQLQuery  `yaml:"graphql,omitempty"         json:"graphql,omitempty"`
+	PathParams       map[string]any `yaml:"path_params,omitempty"     json:"path_params,omitempty"`
+	Params           map[string]any `yaml:"params,omitempty"          json:"params,omitempty"`
+	Headers          map[string]any `yaml:"headers,omitempty"         json:"headers,omitempty"`
+	ResultType       DataKind       `yaml:"type,omitempty"            json:"type,omitempty"`
+	ResponseFormat   ResponseFormat `yaml:"response_format,omitempty" json:"response_format,omitempty"`
+	Scrape           map[string]any `yaml:"scrape,omitempty"          json:"scrape,omitempty"`
+	ResultFilters    []any          `yaml:"filters,omitempty"         json:"filters,omitempty"`
+	Variables        map[string]any `yaml:"variables,omitempty"       json:"variables,omitempty"`
+	Pagination       *Pagination    `yaml:"pagination,omitempty"      json:"pagination,omitempty"`
+	Auth             *AuthConfig    `yaml:"auth,omitempty"            json:"auth,omitempty"`
+	TLS              *TLSConfig     `yaml:"tls,omitempty"             json:"tls,omitempty"`
+	Retry            *RetryPolicy   `yaml:"retry,omitempty"           json:"retry,omitempty"`
+	Timeout          string         `yaml:"timeout,omitempty"         json:"timeout,omitempty"`
+	Cache            *CacheConfig   `yaml:"cache,omitempty"           json:"cache,omitempty"`
+	NoConditional    bool           `yaml:"no_conditional,omitempty"  json:"no_conditional,omitempty"`
 }
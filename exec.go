@@ -0,0 +1,151 @@
+package orchestra
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+
+	"github.com/ghetzel/go-stockutil/typeutil"
+)
+
+// DefaultExecTimeout bounds how long a command may run when its endpoint has no timeout of its own.
+var DefaultExecTimeout = 30 * time.Second
+
+// execWaitDelay is how long to wait for a command's output to close once it has been killed, in
+// case something it started is still holding it open.
+const execWaitDelay = time.Second
+
+// ExecConfig describes a command to run instead of making an HTTP request.  The command, its
+// arguments, working directory and environment are rendered as templates using the same data as
+// endpoint URLs.  If the query has a body (e.g.: from a previous step's result via body_json), it is
+// written to the command's standard input.  Standard output is decoded according to the endpoint's
+// response_format, defaulting to JSON.  The command, and anything it starts, is killed once the
+// endpoint's timeout (or DefaultExecTimeout) elapses.
+type ExecConfig struct {
+	Command []any          `yaml:"command"       json:"command"`
+	Env     map[string]any `yaml:"env,omitempty" json:"env,omitempty"`
+	Dir     string         `yaml:"dir,omitempty" json:"dir,omitempty"`
+}
+
+// stdinBytes returns the request body as the bytes to write to a command's standard input.
+func stdinBytes(body any) []byte {
+	switch b := body.(type) {
+	case nil:
+		return nil
+	case []byte:
+		return b
+	case string:
+		return []byte(b)
+	default:
+		if typeutil.IsMap(b) || typeutil.IsArray(b) {
+			return []byte(compactJSON(b))
+		} else {
+			return []byte(typeutil.String(b))
+		}
+	}
+}
+
+// retrieveViaExec runs the endpoint's command and decodes its output.  A non-zero exit status fails
+// the query, with anything the command wrote to standard error recorded in the response's errors.
+func retrieveViaExec(ctx context.Context, endpoint *Endpoint, request *Request) (any, error) {
+	var config = endpoint.Exec
+
+	if config == nil {
+		return nil, fmt.Errorf("exec: endpoint has no exec configuration")
+	}
+
+	// argv is recorded in the context; command is what's run, with secrets resolved
+	var argv []string
+	var command []string
+
+	for _, arg := range config.Command {
+		if a, err := request.Resolve(ctx, arg); err == nil {
+			argv = append(argv, typeutil.String(request.Render(arg)))
+			command = append(command, typeutil.String(a))
+		} else {
+			return nil, err
+		}
+	}
+
+	if len(argv) == 0 || argv[0] == `` {
+		return nil, fmt.Errorf("exec: no command given")
+	}
+
+	if typeutil.Duration(endpoint.Timeout) <= 0 {
+		var cancel context.CancelFunc
+
+		ctx, cancel = context.WithTimeout(ctx, DefaultExecTimeout)
+		defer cancel()
+	}
+
+	var cmd = exec.CommandContext(ctx, command[0], command[1:]...)
+	var stdout bytes.Buffer
+	var stderr bytes.Buffer
+
+	// the command (and anything it starts) is killed when ctx is done
+	setProcessGroup(cmd)
+	cmd.WaitDelay = execWaitDelay
+
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	cmd.Dir = typeutil.String(request.Render(config.Dir))
+	cmd.Env = os.Environ()
+
+	for k, v := range config.Env {
+		if value, err := request.Resolve(ctx, v); err == nil {
+			cmd.Env = append(cmd.Env, k+`=`+typeutil.String(value))
+		} else {
+			return nil, err
+		}
+	}
+
+	if body, err := request.Body(ctx, endpoint); err == nil {
+		if body != nil {
+			cmd.Stdin = bytes.NewReader(stdinBytes(body))
+		}
+	} else {
+		return nil, err
+	}
+
+	var execContext = map[string]any{
+		`command`: argv,
+	}
+
+	request.Context[`exec`] = execContext
+
+	if err := cmd.Run(); err != nil {
+		var exitErr *exec.ExitError
+
+		if errors.As(err, &exitErr) {
+			execContext[`exit_code`] = exitErr.ExitCode()
+		}
+
+		if ctxErr := ctx.Err(); ctxErr != nil {
+			err = ctxErr
+		}
+
+		err = fmt.Errorf("exec %s: %v", argv[0], err)
+
+		// each line of stderr is recorded as a separate error in the query response
+		if msg := strings.TrimSpace(stderr.String()); msg != `` {
+			err = errors.Join(err, errors.New(msg))
+		}
+
+		return nil, err
+	}
+
+	execContext[`exit_code`] = 0
+
+	var format = endpoint.ResponseFormat
+
+	if format == `` || format == AutoFormat {
+		format = JSONFormat
+	}
+
+	return decodeAs(format, &stdout)
+}
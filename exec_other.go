@@ -0,0 +1,9 @@
+//go:build !unix
+
+package orchestra
+
+import "os/exec"
+
+// setProcessGroup does nothing on platforms without process groups; only the command itself is
+// killed when it is canceled.
+func setProcessGroup(cmd *exec.Cmd) {}
@@ -0,0 +1,106 @@
+package orchestra
+
+import (
+	"testing"
+	"time"
+
+	"github.com/ghetzel/testify/require"
+)
+
+func TestExecEndpoint(t *testing.T) {
+	var assert = require.New(t)
+
+	var opts = NewQueryOptions()
+	opts.Variables = map[string]any{
+		`name`: `world`,
+	}
+
+	var response, err = opts.Query(&Endpoint{
+		Exec: &ExecConfig{
+			Command: []any{`sh`, `-c`, `printf '{"greeting": "%s %s"}' "$GREETING" "$1"`, `sh`, `{{ .vars.name }}`},
+			Env: map[string]any{
+				`GREETING`: `hello`,
+			},
+		},
+	})
+
+	assert.NoError(err)
+	assert.Equal(map[string]any{`greeting`: `hello world`}, response.Result)
+	assert.Equal(0, response.Context[`exec`].(map[string]any)[`exit_code`])
+
+	// stdin from the query body, output as lines
+	opts = NewQueryOptions()
+	opts.Body = []any{`b`, `a`}
+
+	response, err = opts.Query(&Endpoint{
+		ResponseFormat: LinesFormat,
+		Exec: &ExecConfig{
+			Command: []any{`sh`, `-c`, `tr -d '[]"' | tr ',' '\n' | sort`},
+		},
+		ResultFilters: []any{
+			`$uppercase($join($, ","))`,
+		},
+	})
+
+	assert.NoError(err)
+	assert.Equal(`A,B`, response.Result)
+}
+
+func TestExecEndpointFailure(t *testing.T) {
+	var assert = require.New(t)
+
+	var response, err = NewQueryOptions().Query(&Endpoint{
+		Exec: &ExecConfig{
+			Command: []any{`sh`, `-c`, `echo 'something broke' >&2; exit 3`},
+		},
+	})
+
+	assert.Error(err)
+	assert.Contains(err.Error(), `exit status 3`)
+	assert.Contains(response.Errors, `something broke`)
+	assert.Equal(3, response.Context[`exec`].(map[string]any)[`exit_code`])
+
+	_, err = NewQueryOptions().Query(&Endpoint{
+		Timeout: `50ms`,
+		Exec: &ExecConfig{
+			Command: []any{`sleep`, `5`},
+		},
+	})
+
+	assert.Error(err)
+	assert.Contains(err.Error(), `deadline exceeded`)
+
+	// processes the command started are killed too, rather than holding its output open
+	var started = time.Now()
+
+	_, err = NewQueryOptions().Query(&Endpoint{
+		Timeout: `50ms`,
+		Exec: &ExecConfig{
+			Command: []any{`sh`, `-c`, `sleep 10 & wait`},
+		},
+	})
+
+	assert.Error(err)
+	assert.Contains(err.Error(), `deadline exceeded`)
+	assert.True(time.Since(started) < 5*time.Second)
+
+	// commands are always given a timeout
+	DefaultExecTimeout = 50 * time.Millisecond
+	defer func() { DefaultExecTimeout = 30 * time.Second }()
+
+	_, err = NewQueryOptions().Query(&Endpoint{
+		Exec: &ExecConfig{
+			Command: []any{`sleep`, `5`},
+		},
+	})
+
+	assert.Error(err)
+	assert.Contains(err.Error(), `deadline exceeded`)
+
+	_, err = NewQueryOptions().Query(&Endpoint{
+		Exec: &ExecConfig{},
+	})
+
+	assert.Error(err)
+	assert.Contains(err.Error(), `no command`)
+}
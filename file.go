@@ -0,0 +1,214 @@
+package orchestra
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/ghetzel/go-stockutil/fileutil"
+	"github.com/ghetzel/go-stockutil/sliceutil"
+	"github.com/ghetzel/go-stockutil/typeutil"
+)
+
+const FileScheme = `file://`
+
+// FormatForExtension returns the response format implied by a filename's extension, or an empty
+// string if the extension is not recognized.
+func FormatForExtension(filename string) ResponseFormat {
+	switch strings.ToLower(filepath.Ext(filename)) {
+	case `.json`:
+		return JSONFormat
+	case `.yaml`, `.yml`:
+		return YAMLFormat
+	case `.csv`:
+		return CSVFormat
+	case `.tsv`:
+		return TSVFormat
+	case `.ndjson`, `.jsonl`:
+		return NDJSONFormat
+	case `.xml`:
+		return XMLFormat
+	case `.html`, `.htm`:
+		return HTMLFormat
+	case `.txt`:
+		return TextFormat
+	default:
+		return ``
+	}
+}
+
+// datasetCandidates returns the files a path rendered for a request may refer to: the path itself if
+// it's absolute, or else the path within each directory in DatasetsPath.  Since the path may have
+// been rendered from variables, it must not lead outside of those directories.
+func datasetCandidates(path string) ([]string, error) {
+	var candidates []string
+
+	if p, err := fileutil.ExpandUser(path); err == nil {
+		path = p
+	} else {
+		return nil, err
+	}
+
+	if filepath.IsAbs(path) {
+		candidates = []string{filepath.Clean(path)}
+	} else {
+		for _, dir := range DatasetsPath {
+			candidates = append(candidates, filepath.Join(dir, path))
+		}
+	}
+
+	for _, candidate := range candidates {
+		if !withinDatasetsPath(candidate) {
+			return nil, fmt.Errorf("%q is outside of the datasets path", path)
+		}
+	}
+
+	return candidates, nil
+}
+
+// withinDatasetsPath returns whether the given path lies within one of the DatasetsPath directories.
+func withinDatasetsPath(path string) bool {
+	for _, dir := range DatasetsPath {
+		if d, err := filepath.Abs(dir); err == nil {
+			if p, err := filepath.Abs(path); err == nil {
+				if rel, err := filepath.Rel(d, p); err == nil && rel != `..` && !strings.HasPrefix(rel, `..`+string(filepath.Separator)) {
+					return true
+				}
+			}
+		}
+	}
+
+	return false
+}
+
+// readRequestFile reads a file named by a request (see datasetCandidates).
+func readRequestFile(path string) ([]byte, error) {
+	if candidates, err := datasetCandidates(path); err == nil {
+		for _, candidate := range candidates {
+			if data, err := os.ReadFile(candidate); err == nil {
+				return data, nil
+			} else if !os.IsNotExist(err) {
+				return nil, err
+			}
+		}
+
+		return nil, fmt.Errorf("%q not found", path)
+	} else {
+		return nil, err
+	}
+}
+
+// resolveFiles returns the files referred to by a file:// URL.  The path may be a file, a
+// directory (meaning every file in it with a recognized extension), or a glob pattern.  Relative
+// paths are resolved against each directory in DatasetsPath, using the first one that matches, and
+// no path may lead outside of them.
+func resolveFiles(fileURL string) ([]string, bool, error) {
+	var path = strings.TrimPrefix(fileURL, FileScheme)
+	var candidates []string
+
+	if c, err := datasetCandidates(path); err == nil {
+		candidates = c
+	} else {
+		return nil, false, err
+	}
+
+	for _, candidate := range candidates {
+		if strings.ContainsAny(candidate, `*?[`) {
+			if matches, err := filepath.Glob(candidate); err != nil {
+				return nil, false, err
+			} else if len(matches) > 0 {
+				sort.Strings(matches)
+				return matches, true, nil
+			}
+		} else if fileutil.DirExists(candidate) {
+			if entries, err := os.ReadDir(candidate); err == nil {
+				var files = make([]string, 0)
+
+				for _, entry := range entries {
+					if !entry.IsDir() && FormatForExtension(entry.Name()) != `` {
+						files = append(files, filepath.Join(candidate, entry.Name()))
+					}
+				}
+
+				return files, true, nil
+			} else {
+				return nil, false, err
+			}
+		} else if fileutil.FileExists(candidate) {
+			return []string{candidate}, false, nil
+		}
+	}
+
+	return nil, false, fmt.Errorf("no files found matching %q", path)
+}
+
+// decodeFile reads and decodes a single file, using the endpoint's response format if one is given
+// and otherwise guessing from the file extension.
+func (endpoint *Endpoint) decodeFile(filename string) (any, error) {
+	var file, err = os.Open(filename)
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer file.Close()
+
+	if len(endpoint.Scrape) > 0 {
+		return scrapeHTML(file, endpoint.Scrape)
+	}
+
+	var format = endpoint.ResponseFormat
+
+	if format == `` || format == AutoFormat {
+		if format = FormatForExtension(filename); format == `` {
+			format = JSONFormat
+		}
+	}
+
+	return decodeAs(format, file)
+}
+
+// retrieveViaFile reads data from local files instead of over HTTP.  A single file yields its
+// decoded contents; multiple files (from a directory or glob) yield a list of all of their
+// contents, with files that decode to lists contributing each of their items.
+func retrieveViaFile(ctx context.Context, endpoint *Endpoint, request *Request) (any, error) {
+	var files, multiple, err = resolveFiles(request.URL)
+	var out any
+
+	if err != nil {
+		return nil, err
+	}
+
+	request.Context[`files`] = files
+
+	if multiple {
+		var items = make([]any, 0)
+
+		for _, filename := range files {
+			if err := ctx.Err(); err != nil {
+				return nil, err
+			}
+
+			if value, err := endpoint.decodeFile(filename); err == nil {
+				if typeutil.IsArray(value) {
+					items = append(items, sliceutil.Sliceify(value)...)
+				} else {
+					items = append(items, value)
+				}
+			} else {
+				return nil, fmt.Errorf("%s: %v", filename, err)
+			}
+		}
+
+		out = items
+	} else if value, err := endpoint.decodeFile(files[0]); err == nil {
+		out = value
+	} else {
+		return nil, fmt.Errorf("%s: %v", files[0], err)
+	}
+
+	return out, nil
+}
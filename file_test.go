@@ -0,0 +1,88 @@
+package orchestra
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/ghetzel/testify/require"
+)
+
+func TestFileEndpoints(t *testing.T) {
+	var assert = require.New(t)
+	var dir = t.TempDir()
+	var write = func(name string, content string) {
+		var path = filepath.Join(dir, name)
+
+		assert.NoError(os.MkdirAll(filepath.Dir(path), 0700))
+		assert.NoError(os.WriteFile(path, []byte(content), 0600))
+	}
+
+	write(`ref/colors.json`, `[{"id": 1, "name": "red"}, {"id": 2, "name": "blue"}]`)
+	write(`ref/sizes.yaml`, "- id: 3\n  name: large\n")
+	write(`ref/README`, `ignored`)
+	write(`people.csv`, "name,team\nalice,ops\nbob,dev\n")
+	write(`events.ndjson`, "{\"n\":1}\n{\"n\":2}\n")
+
+	var saved = DatasetsPath
+	DatasetsPath = []string{filepath.Join(dir, `nope`), dir}
+	defer func() { DatasetsPath = saved }()
+
+	var query = func(url string, vars map[string]any, filters ...any) (*QueryResponse, error) {
+		var opts = NewQueryOptions()
+		opts.Variables = vars
+
+		return opts.Query(&Endpoint{
+			URL:           url,
+			ResultFilters: filters,
+		})
+	}
+
+	// relative path, templated from variables
+	var response, err = query(`file://{{ .vars.file }}.csv`, map[string]any{
+		`file`: `people`,
+	}, `$[team = "dev"].name`)
+
+	assert.NoError(err)
+	assert.Equal(`bob`, response.Result)
+
+	// absolute path
+	response, err = query(`file://`+filepath.Join(dir, `events.ndjson`), nil, `$sum(n)`)
+	assert.NoError(err)
+	assert.EqualValues(3, response.Result)
+
+	// directory: every recognized file, concatenated
+	response, err = query(`file://ref`, nil, `name`)
+	assert.NoError(err)
+	assert.Equal([]any{`red`, `blue`, `large`}, response.Result)
+
+	// glob
+	response, err = query(`file://ref/*.yaml`, nil)
+	assert.NoError(err)
+	assert.Equal([]any{map[string]any{`id`: 3, `name`: `large`}}, response.Result)
+	assert.Equal([]string{filepath.Join(dir, `ref/sizes.yaml`)}, response.Context[`files`])
+
+	_, err = query(`file://missing.json`, nil)
+	assert.Error(err)
+	assert.Contains(err.Error(), `no files found`)
+
+	// paths can't lead outside of the datasets path
+	for _, file := range []string{`../secret`, `ref/../../secret`, `/etc/passwd`} {
+		_, err = query(`file://{{ .vars.file }}`, map[string]any{
+			`file`: file,
+		})
+
+		assert.Error(err, file)
+		assert.Contains(err.Error(), `is outside of the datasets path`, file)
+	}
+}
+
+func TestFormatForExtension(t *testing.T) {
+	var assert = require.New(t)
+
+	assert.Equal(JSONFormat, FormatForExtension(`a.JSON`))
+	assert.Equal(YAMLFormat, FormatForExtension(`a.yml`))
+	assert.Equal(NDJSONFormat, FormatForExtension(`a.jsonl`))
+	assert.Equal(CSVFormat, FormatForExtension(`a.csv`))
+	assert.Equal(ResponseFormat(``), FormatForExtension(`README`))
+}
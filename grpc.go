@@ -0,0 +1,365 @@
+package orchestra
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/ghetzel/go-stockutil/typeutil"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	reflectionpb "google.golang.org/grpc/reflection/grpc_reflection_v1"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protodesc"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/reflect/protoregistry"
+	"google.golang.org/protobuf/types/descriptorpb"
+	"google.golang.org/protobuf/types/dynamicpb"
+)
+
+// GRPCConfig describes a unary gRPC call to make instead of an HTTP request.  The method's
+// descriptors are read from a protoset file (as produced by "protoc --descriptor_set_out
+// --include_imports") if one is given, and otherwise are fetched using server reflection.  The
+// request message is built from the query's body if it has one, and from its variables otherwise.
+// Endpoint headers are sent as request metadata.
+type GRPCConfig struct {
+	Address   string `yaml:"address"             json:"address"`
+	Method    string `yaml:"method"              json:"method"`
+	Protoset  string `yaml:"protoset,omitempty"  json:"protoset,omitempty"`
+	Plaintext bool   `yaml:"plaintext,omitempty" json:"plaintext,omitempty"`
+}
+
+var grpcConns sync.Map
+var grpcMethods sync.Map
+
+// fullMethod splits a method name given as "pkg.Service/Method" or "pkg.Service.Method" into its
+// service and method names.
+func (config *GRPCConfig) fullMethod() (string, string, error) {
+	var name = strings.TrimPrefix(config.Method, `/`)
+	var i = strings.LastIndex(name, `/`)
+
+	if i < 0 {
+		i = strings.LastIndex(name, `.`)
+	}
+
+	if i <= 0 || i == len(name)-1 {
+		return ``, ``, fmt.Errorf("grpc: method must be given as service/method, got %q", config.Method)
+	}
+
+	return name[:i], name[i+1:], nil
+}
+
+// conn returns the (shared) client connection to the given address, using the endpoint's TLS
+// configuration (if any) unless the connection is plaintext.
+func (config *GRPCConfig) conn(ctx context.Context, endpoint *Endpoint, address string) (*grpc.ClientConn, error) {
+	var key = fmt.Sprintf("%s|%v|%s", address, config.Plaintext, endpoint.TLS.key())
+
+	if conn, ok := grpcConns.Load(key); ok {
+		return conn.(*grpc.ClientConn), nil
+	}
+
+	var creds credentials.TransportCredentials
+
+	if config.Plaintext {
+		creds = insecure.NewCredentials()
+	} else if tc, err := endpoint.tlsConfig(ctx); err != nil {
+		return nil, err
+	} else if tc != nil {
+		creds = credentials.NewTLS(tc.Clone())
+	} else {
+		creds = credentials.NewTLS(&tls.Config{})
+	}
+
+	if conn, err := grpc.NewClient(address, grpc.WithTransportCredentials(creds)); err == nil {
+		if existing, loaded := grpcConns.LoadOrStore(key, conn); loaded {
+			conn.Close()
+			return existing.(*grpc.ClientConn), nil
+		} else {
+			return conn, nil
+		}
+	} else {
+		return nil, err
+	}
+}
+
+// method resolves the descriptor for the configured method, caching it for subsequent calls.
+func (config *GRPCConfig) method(ctx context.Context, conn *grpc.ClientConn, address string) (protoreflect.MethodDescriptor, error) {
+	var service, name, err = config.fullMethod()
+
+	if err != nil {
+		return nil, err
+	}
+
+	var source = `reflect:` + address
+
+	if config.Protoset != `` {
+		source = `protoset:` + config.Protoset
+	}
+
+	var key = source + `|` + service + `/` + name
+
+	if md, ok := grpcMethods.Load(key); ok {
+		return md.(protoreflect.MethodDescriptor), nil
+	}
+
+	var files *protoregistry.Files
+
+	if config.Protoset != `` {
+		files, err = loadProtoset(config.Protoset)
+	} else {
+		files, err = reflectFiles(ctx, conn, service)
+	}
+
+	if err != nil {
+		return nil, fmt.Errorf("grpc: %v", err)
+	}
+
+	if desc, err := files.FindDescriptorByName(protoreflect.FullName(service)); err == nil {
+		if sd, ok := desc.(protoreflect.ServiceDescriptor); ok {
+			if md := sd.Methods().ByName(protoreflect.Name(name)); md != nil {
+				if md.IsStreamingClient() || md.IsStreamingServer() {
+					return nil, fmt.Errorf("grpc: %s/%s is a streaming method", service, name)
+				}
+
+				grpcMethods.Store(key, md)
+				return md, nil
+			} else {
+				return nil, fmt.Errorf("grpc: service %s has no method %q", service, name)
+			}
+		} else {
+			return nil, fmt.Errorf("grpc: %s is not a service", service)
+		}
+	} else {
+		return nil, fmt.Errorf("grpc: service %s: %v", service, err)
+	}
+}
+
+// loadProtoset reads a serialized FileDescriptorSet from disk.  Relative paths are resolved against
+// each directory in DatasetsPath.
+func loadProtoset(path string) (*protoregistry.Files, error) {
+	if data, filename, err := readDatasetFile(path); err == nil {
+		var set descriptorpb.FileDescriptorSet
+
+		if err := proto.Unmarshal(data, &set); err != nil {
+			return nil, fmt.Errorf("protoset %s: %v", filename, err)
+		}
+
+		var protos = make(map[string]*descriptorpb.FileDescriptorProto)
+
+		for _, fdp := range set.GetFile() {
+			protos[fdp.GetName()] = fdp
+		}
+
+		return buildFiles(protos)
+	} else {
+		return nil, fmt.Errorf("protoset %v", err)
+	}
+}
+
+// reflectFiles fetches the file defining service, along with all of its dependencies, from the
+// server's reflection service.
+func reflectFiles(ctx context.Context, conn *grpc.ClientConn, service string) (*protoregistry.Files, error) {
+	var stream, err = reflectionpb.NewServerReflectionClient(conn).ServerReflectionInfo(ctx)
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer stream.CloseSend()
+
+	var protos = make(map[string]*descriptorpb.FileDescriptorProto)
+
+	var request = func(req *reflectionpb.ServerReflectionRequest) error {
+		if err := stream.Send(req); err != nil {
+			return err
+		}
+
+		if res, err := stream.Recv(); err == nil {
+			if e := res.GetErrorResponse(); e != nil {
+				return fmt.Errorf("reflection: %s", e.GetErrorMessage())
+			}
+
+			for _, data := range res.GetFileDescriptorResponse().GetFileDescriptorProto() {
+				var fdp = new(descriptorpb.FileDescriptorProto)
+
+				if err := proto.Unmarshal(data, fdp); err == nil {
+					protos[fdp.GetName()] = fdp
+				} else {
+					return err
+				}
+			}
+
+			return nil
+		} else {
+			return err
+		}
+	}
+
+	if err := request(&reflectionpb.ServerReflectionRequest{
+		MessageRequest: &reflectionpb.ServerReflectionRequest_FileContainingSymbol{
+			FileContainingSymbol: service,
+		},
+	}); err != nil {
+		return nil, err
+	}
+
+	// fetch any dependencies the server didn't send along with the service's file
+	for {
+		var missing []string
+
+		for _, fdp := range protos {
+			for _, dep := range fdp.GetDependency() {
+				if _, ok := protos[dep]; ok {
+					continue
+				} else if _, err := protoregistry.GlobalFiles.FindFileByPath(dep); err == nil {
+					continue
+				}
+
+				missing = append(missing, dep)
+			}
+		}
+
+		if len(missing) == 0 {
+			break
+		}
+
+		for _, dep := range missing {
+			if err := request(&reflectionpb.ServerReflectionRequest{
+				MessageRequest: &reflectionpb.ServerReflectionRequest_FileByFilename{
+					FileByFilename: dep,
+				},
+			}); err != nil {
+				return nil, err
+			} else if _, ok := protos[dep]; !ok {
+				return nil, fmt.Errorf("reflection: server did not return %s", dep)
+			}
+		}
+	}
+
+	return buildFiles(protos)
+}
+
+// buildFiles links a set of file descriptors into a registry, falling back to the descriptors
+// compiled into this binary (e.g.: well-known types) for any dependencies not in the set.
+func buildFiles(protos map[string]*descriptorpb.FileDescriptorProto) (*protoregistry.Files, error) {
+	var files = new(protoregistry.Files)
+	var add func(name string) error
+
+	add = func(name string) error {
+		if _, err := files.FindFileByPath(name); err == nil {
+			return nil
+		} else if fdp, ok := protos[name]; ok {
+			for _, dep := range fdp.GetDependency() {
+				if err := add(dep); err != nil {
+					return err
+				}
+			}
+
+			if fd, err := protodesc.NewFile(fdp, files); err == nil {
+				return files.RegisterFile(fd)
+			} else {
+				return err
+			}
+		} else if fd, err := protoregistry.GlobalFiles.FindFileByPath(name); err == nil {
+			return files.RegisterFile(fd)
+		} else {
+			return fmt.Errorf("missing descriptor for %s", name)
+		}
+	}
+
+	for name := range protos {
+		if err := add(name); err != nil {
+			return nil, err
+		}
+	}
+
+	return files, nil
+}
+
+// retrieveViaGRPC makes the endpoint's unary gRPC call, returning the response message as
+// JSON-native values.
+func retrieveViaGRPC(ctx context.Context, endpoint *Endpoint, request *Request) (any, error) {
+	var config = endpoint.GRPC
+
+	if config == nil {
+		return nil, fmt.Errorf("grpc: endpoint has no grpc configuration")
+	}
+
+	var address = FormatString(config.Address, request.Context)
+	var conn *grpc.ClientConn
+
+	if a, err := request.ResolveString(ctx, config.Address); err != nil {
+		return nil, err
+	} else if c, err := config.conn(ctx, endpoint, a); err == nil {
+		conn = c
+	} else {
+		return nil, err
+	}
+
+	var md protoreflect.MethodDescriptor
+
+	if m, err := config.method(ctx, conn, address); err == nil {
+		md = m
+	} else {
+		return nil, err
+	}
+
+	var fields any = request.Vars
+
+	if body, err := request.Body(ctx, endpoint); err != nil {
+		return nil, err
+	} else if body != nil {
+		fields = body
+	}
+
+	var message = dynamicpb.NewMessage(md.Input())
+	var response = dynamicpb.NewMessage(md.Output())
+
+	if data, err := json.Marshal(fields); err == nil {
+		if err := (protojson.UnmarshalOptions{DiscardUnknown: true}).Unmarshal(data, message); err != nil {
+			return nil, fmt.Errorf("grpc: request %s: %v", md.Input().FullName(), err)
+		}
+	} else {
+		return nil, err
+	}
+
+	var fullMethod = fmt.Sprintf("/%s/%s", md.Parent().FullName(), md.Name())
+	var outgoing = metadata.New(nil)
+
+	if headers, err := request.ResolveHeaders(ctx, request.Headers); err != nil {
+		return nil, err
+	} else if headers, err := endpoint.authorize(ctx, headers); err == nil {
+		for k, v := range headers {
+			outgoing.Append(k, typeutil.String(v))
+		}
+	} else {
+		return nil, err
+	}
+
+	request.Context[`grpc`] = map[string]any{
+		`address`: address,
+		`method`:  fullMethod,
+	}
+
+	if err := conn.Invoke(metadata.NewOutgoingContext(ctx, outgoing), fullMethod, message, response); err != nil {
+		return nil, fmt.Errorf("grpc %s: %v", fullMethod, err)
+	}
+
+	var out any
+
+	if data, err := (protojson.MarshalOptions{UseProtoNames: true, EmitUnpopulated: true}).Marshal(response); err == nil {
+		if err := json.Unmarshal(data, &out); err != nil {
+			return nil, err
+		}
+	} else {
+		return nil, err
+	}
+
+	return out, nil
+}
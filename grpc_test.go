@@ -0,0 +1,120 @@
+package orchestra
+
+import (
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/ghetzel/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/reflection"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protodesc"
+	"google.golang.org/protobuf/types/descriptorpb"
+)
+
+func startTestGRPCServer(t *testing.T, reflect bool) string {
+	var listener, err = net.Listen(`tcp`, `127.0.0.1:0`)
+	require.NoError(t, err)
+
+	var server = grpc.NewServer()
+	var checker = health.NewServer()
+
+	checker.SetServingStatus(`orders`, healthpb.HealthCheckResponse_SERVING)
+	checker.SetServingStatus(`billing`, healthpb.HealthCheckResponse_NOT_SERVING)
+	healthpb.RegisterHealthServer(server, checker)
+
+	if reflect {
+		reflection.Register(server)
+	}
+
+	go server.Serve(listener)
+	t.Cleanup(server.Stop)
+
+	return listener.Addr().String()
+}
+
+func TestGRPCEndpointReflection(t *testing.T) {
+	var assert = require.New(t)
+	var address = startTestGRPCServer(t, true)
+
+	var endpoint = &Endpoint{
+		GRPC: &GRPCConfig{
+			Address:   address,
+			Method:    `grpc.health.v1.Health/Check`,
+			Plaintext: true,
+		},
+		RequestBody: map[string]any{
+			`service`: `{{ .vars.service }}`,
+		},
+	}
+
+	var opts = NewQueryOptions()
+	opts.Variables = map[string]any{
+		`service`: `orders`,
+	}
+
+	var response, err = opts.Query(endpoint)
+	assert.NoError(err)
+	assert.Equal(map[string]any{`status`: `SERVING`}, response.Result)
+
+	// request built from variables when there is no body
+	endpoint.RequestBody = nil
+	endpoint.ResultFilters = []any{`status`}
+	opts.Variables[`service`] = `billing`
+
+	response, err = opts.Query(endpoint)
+	assert.NoError(err)
+	assert.Equal(`NOT_SERVING`, response.Result)
+
+	opts.Variables[`service`] = `unknown`
+	_, err = opts.Query(endpoint)
+	assert.Error(err)
+	assert.Contains(err.Error(), `NotFound`)
+
+	_, err = NewQueryOptions().Query(&Endpoint{
+		GRPC: &GRPCConfig{
+			Address:   address,
+			Method:    `grpc.health.v1.Health.Nope`,
+			Plaintext: true,
+		},
+	})
+
+	assert.Error(err)
+	assert.Contains(err.Error(), `no method "Nope"`)
+}
+
+func TestGRPCEndpointProtoset(t *testing.T) {
+	var assert = require.New(t)
+	var address = startTestGRPCServer(t, false)
+	var protoset = filepath.Join(t.TempDir(), `health.protoset`)
+
+	var data, err = proto.Marshal(&descriptorpb.FileDescriptorSet{
+		File: []*descriptorpb.FileDescriptorProto{
+			protodesc.ToFileDescriptorProto(healthpb.File_grpc_health_v1_health_proto),
+		},
+	})
+
+	assert.NoError(err)
+	assert.NoError(os.WriteFile(protoset, data, 0600))
+
+	var opts = NewQueryOptions()
+	opts.Variables = map[string]any{
+		`service`: `orders`,
+	}
+
+	response, err := opts.Query(&Endpoint{
+		GRPC: &GRPCConfig{
+			Address:   address,
+			Method:    `grpc.health.v1.Health.Check`,
+			Protoset:  protoset,
+			Plaintext: true,
+		},
+	})
+
+	assert.NoError(err)
+	assert.Equal(map[string]any{`status`: `SERVING`}, response.Result)
+}
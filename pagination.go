@@ -0,0 +1,203 @@
+package orchestra
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+
+	"github.com/blues/jsonata-go"
+	"github.com/ghetzel/go-stockutil/sliceutil"
+	"github.com/ghetzel/go-stockutil/typeutil"
+)
+
+const DefaultPaginationMaxPages = 100
+
+type PaginationStyle string
+
+const (
+	OffsetPagination PaginationStyle = `offset`
+	PagePagination   PaginationStyle = `page`
+	CursorPagination PaginationStyle = `cursor`
+	LinkPagination   PaginationStyle = `link`
+)
+
+var rxLinkHeaderEntry = regexp.MustCompile(`<([^>]*)>\s*((?:;\s*[^;,]+)*)`)
+var rxLinkHeaderRel = regexp.MustCompile(`(?i);\s*rel\s*=\s*"?([^";]+)"?`)
+
+// Pagination describes how to retrieve successive pages of results from an endpoint.  The items
+// from every page are concatenated into a single list before the endpoint's filters are applied.
+type Pagination struct {
+	Style       PaginationStyle `yaml:"style"                  json:"style"`
+	Limit       int             `yaml:"limit,omitempty"        json:"limit,omitempty"`
+	LimitParam  string          `yaml:"limit_param,omitempty"  json:"limit_param,omitempty"`
+	OffsetParam string          `yaml:"offset_param,omitempty" json:"offset_param,omitempty"`
+	PageParam   string          `yaml:"page_param,omitempty"   json:"page_param,omitempty"`
+	StartPage   int             `yaml:"start_page,omitempty"   json:"start_page,omitempty"`
+	CursorParam string          `yaml:"cursor_param,omitempty" json:"cursor_param,omitempty"`
+	CursorQuery any             `yaml:"cursor,omitempty"       json:"cursor,omitempty"`
+	ItemsQuery  any             `yaml:"items,omitempty"        json:"items,omitempty"`
+	MaxPages    int             `yaml:"max_pages,omitempty"    json:"max_pages,omitempty"`
+	MaxItems    int             `yaml:"max_items,omitempty"    json:"max_items,omitempty"`
+}
+
+// pageFetchFunc retrieves and decodes a single page.  An empty path requests the endpoint URL itself.
+type pageFetchFunc func(path string, params map[string]any) (*http.Response, any, error)
+
+func (pager *Pagination) maxPages() int {
+	if pager.MaxPages > 0 {
+		return pager.MaxPages
+	} else {
+		return DefaultPaginationMaxPages
+	}
+}
+
+func (pager *Pagination) paramName(explicit string, fallback string) string {
+	if explicit != `` {
+		return explicit
+	} else {
+		return fallback
+	}
+}
+
+// validate checks that the pagination style is supported and has what it needs, so that
+// misconfigurations are caught before any page is requested.
+func (pager *Pagination) validate() error {
+	switch pager.Style {
+	case OffsetPagination, PagePagination, LinkPagination:
+	case CursorPagination:
+		if typeutil.IsZero(pager.CursorQuery) {
+			return fmt.Errorf("pagination: cursor style requires a cursor expression")
+		}
+	default:
+		return fmt.Errorf("pagination: unsupported style %q", pager.Style)
+	}
+
+	return nil
+}
+
+// retrieve calls fetch repeatedly until the pages are exhausted or one of the configured caps is
+// reached, and returns the concatenated items along with the number of pages that were requested.
+func (pager *Pagination) retrieve(fetch pageFetchFunc, params map[string]any, vars map[string]any) ([]any, int, error) {
+	var items = make([]any, 0)
+	var path string
+	var cursor string
+	var pages int
+
+	if err := pager.validate(); err != nil {
+		return nil, 0, err
+	}
+
+	for pages < pager.maxPages() {
+		var pageParams = make(map[string]any)
+
+		for k, v := range params {
+			pageParams[k] = v
+		}
+
+		if pager.Limit > 0 && pager.Style != LinkPagination {
+			pageParams[pager.paramName(pager.LimitParam, `limit`)] = pager.Limit
+		}
+
+		switch pager.Style {
+		case OffsetPagination:
+			pageParams[pager.paramName(pager.OffsetParam, `offset`)] = len(items)
+		case PagePagination:
+			var start = pager.StartPage
+
+			if start == 0 {
+				start = 1
+			}
+
+			pageParams[pager.paramName(pager.PageParam, `page`)] = start + pages
+		case CursorPagination:
+			if cursor != `` {
+				pageParams[pager.paramName(pager.CursorParam, `cursor`)] = cursor
+			}
+		case LinkPagination:
+			// the "next" link already carries its own querystring; don't clobber those values
+			if path != `` {
+				if u, err := url.Parse(path); err == nil {
+					for k := range u.Query() {
+						delete(pageParams, k)
+					}
+				}
+			}
+		}
+
+		var response, body, err = fetch(path, pageParams)
+
+		if err != nil {
+			return nil, pages, fmt.Errorf("page %d: %v", pages+1, err)
+		}
+
+		pages += 1
+
+		var pageItems []any
+
+		if typeutil.IsZero(pager.ItemsQuery) {
+			pageItems = sliceutil.Sliceify(body)
+		} else if extracted, err := applyJsonata(body, vars, pager.ItemsQuery); err == nil {
+			pageItems = sliceutil.Sliceify(extracted)
+		} else if !errors.Is(err, jsonata.ErrUndefined) {
+			return nil, pages, fmt.Errorf("pagination items: %v", err)
+		}
+
+		items = append(items, pageItems...)
+
+		if pager.MaxItems > 0 && len(items) >= pager.MaxItems {
+			items = items[:pager.MaxItems]
+			break
+		} else if len(pageItems) == 0 {
+			break
+		}
+
+		switch pager.Style {
+		case OffsetPagination, PagePagination:
+			if pager.Limit > 0 && len(pageItems) < pager.Limit {
+				return items, pages, nil
+			}
+		case CursorPagination:
+			if next, err := applyJsonata(body, vars, pager.CursorQuery); err == nil {
+				if typeutil.IsZero(next) {
+					return items, pages, nil
+				}
+
+				cursor = typeutil.String(next)
+			} else if errors.Is(err, jsonata.ErrUndefined) {
+				return items, pages, nil
+			} else {
+				return nil, pages, fmt.Errorf("pagination cursor: %v", err)
+			}
+		case LinkPagination:
+			if response == nil || response.Request == nil {
+				return items, pages, nil
+			} else if next := nextLink(response.Header); next == `` {
+				return items, pages, nil
+			} else if nextURL, err := response.Request.URL.Parse(next); err == nil {
+				path = nextURL.String()
+			} else {
+				return nil, pages, fmt.Errorf("pagination link: %v", err)
+			}
+		}
+	}
+
+	return items, pages, nil
+}
+
+// nextLink returns the target of the first rel="next" entry in any RFC 5988 Link headers.
+func nextLink(header http.Header) string {
+	for _, value := range header.Values(`Link`) {
+		for _, match := range rxLinkHeaderEntry.FindAllStringSubmatch(value, -1) {
+			if rel := rxLinkHeaderRel.FindStringSubmatch(match[2]); rel != nil {
+				if sliceutil.ContainsString(strings.Fields(strings.ToLower(rel[1])), `next`) {
+					return strings.TrimSpace(match[1])
+				}
+			}
+		}
+	}
+
+	return ``
+}
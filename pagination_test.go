@@ -0,0 +1,186 @@
+package orchestra
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+
+	"github.com/ghetzel/go-stockutil/httputil"
+	"github.com/ghetzel/go-stockutil/sliceutil"
+	"github.com/ghetzel/go-stockutil/typeutil"
+	"github.com/ghetzel/testify/require"
+)
+
+var testPaginatedItems = []string{`a`, `b`, `c`, `d`, `e`, `f`, `g`}
+
+func testPaginationSlice(start int, limit int) []string {
+	if start >= len(testPaginatedItems) {
+		return []string{}
+	} else if end := start + limit; end < len(testPaginatedItems) {
+		return testPaginatedItems[start:end]
+	} else {
+		return testPaginatedItems[start:]
+	}
+}
+
+var TestPaginationServer = func() *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var limit = int(httputil.QInt(r, `limit`, 3))
+
+		switch r.URL.Path {
+		case `/offset`:
+			httputil.RespondJSON(w, testPaginationSlice(int(httputil.QInt(r, `offset`)), limit))
+		case `/page`:
+			httputil.RespondJSON(w, testPaginationSlice(int(httputil.QInt(r, `p`)-1)*limit, limit))
+		case `/cursor`:
+			var start = int(typeutil.Int(httputil.Q(r, `after`)))
+			var next any
+
+			if end := start + limit; end < len(testPaginatedItems) {
+				next = end
+			}
+
+			httputil.RespondJSON(w, map[string]any{
+				`data`: testPaginationSlice(start, limit),
+				`next`: next,
+			})
+		case `/link`:
+			var page = int(httputil.QInt(r, `page`, 1))
+
+			if (page * limit) < len(testPaginatedItems) {
+				w.Header().Set(`Link`, fmt.Sprintf(`</link?page=%d&limit=%d>; rel="next", </link?page=1>; rel="first"`, page+1, limit))
+			}
+
+			httputil.RespondJSON(w, testPaginationSlice((page-1)*limit, limit))
+		default:
+			httputil.RespondJSON(w, fmt.Errorf("nope"), http.StatusNotFound)
+		}
+	}))
+}()
+
+func TestPaginationOffset(t *testing.T) {
+	var assert = require.New(t)
+
+	var response, err = NewQueryOptions().Query(&Endpoint{
+		URL: TestPaginationServer.URL + `/offset`,
+		Pagination: &Pagination{
+			Style: OffsetPagination,
+			Limit: 3,
+		},
+	})
+
+	assert.NoError(err)
+	assert.EqualValues(testPaginatedItems, sliceutil.Stringify(response.Result))
+	assert.Equal(3, response.Context[`pages`])
+}
+
+func TestPaginationPage(t *testing.T) {
+	var assert = require.New(t)
+
+	var response, err = NewQueryOptions().Query(&Endpoint{
+		URL: TestPaginationServer.URL + `/page`,
+		Pagination: &Pagination{
+			Style:     PagePagination,
+			PageParam: `p`,
+			Limit:     2,
+			MaxItems:  5,
+		},
+	})
+
+	assert.NoError(err)
+	assert.EqualValues([]any{`a`, `b`, `c`, `d`, `e`}, response.Result)
+	assert.Equal(3, response.Context[`pages`])
+}
+
+func TestPaginationCursor(t *testing.T) {
+	var assert = require.New(t)
+
+	var response, err = NewQueryOptions().Query(&Endpoint{
+		URL: TestPaginationServer.URL + `/cursor`,
+		Pagination: &Pagination{
+			Style:       CursorPagination,
+			CursorParam: `after`,
+			CursorQuery: `next`,
+			ItemsQuery:  `data`,
+		},
+		ResultFilters: []any{
+			`$.$uppercase($)`,
+		},
+	})
+
+	assert.NoError(err)
+	assert.EqualValues([]any{`A`, `B`, `C`, `D`, `E`, `F`, `G`}, response.Result)
+	assert.Equal(3, response.Context[`pages`])
+}
+
+func TestPaginationValidation(t *testing.T) {
+	var assert = require.New(t)
+	var calls int32
+
+	var server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&calls, 1)
+		httputil.RespondJSON(w, map[string]any{`data`: []string{`a`}})
+	}))
+	defer server.Close()
+
+	for _, pager := range []*Pagination{
+		{Style: CursorPagination, ItemsQuery: `data`},
+		{Style: `seek`},
+		{},
+	} {
+		var _, err = NewQueryOptions().Query(&Endpoint{
+			URL:        server.URL,
+			Pagination: pager,
+		})
+
+		assert.Error(err)
+		assert.Contains(err.Error(), `pagination:`)
+
+		var datasets = NewConfig().Datasets
+		datasets.Endpoints[`bad-pages`] = &Endpoint{URL: server.URL, Pagination: pager}
+
+		err = loadDatasets(datasets)
+		assert.Error(err)
+		assert.Contains(err.Error(), `endpoint bad-pages: pagination:`)
+	}
+
+	// nothing is requested from a misconfigured endpoint
+	assert.EqualValues(0, atomic.LoadInt32(&calls))
+}
+
+func TestPaginationLinkHeader(t *testing.T) {
+	var assert = require.New(t)
+
+	var response, err = NewQueryOptions().Query(&Endpoint{
+		URL: TestPaginationServer.URL + `/link`,
+		Params: map[string]any{
+			`limit`: 2,
+		},
+		Pagination: &Pagination{
+			Style:    LinkPagination,
+			MaxPages: 2,
+		},
+	})
+
+	assert.NoError(err)
+	assert.EqualValues([]any{`a`, `b`, `c`, `d`}, response.Result)
+	assert.Equal(2, response.Context[`pages`])
+}
+
+func TestNextLink(t *testing.T) {
+	var assert = require.New(t)
+
+	assert.Equal(`/x?page=2`, nextLink(http.Header{
+		`Link`: []string{`</x?page=1>; rel="prev", </x?page=2>; rel="next"`},
+	}))
+
+	assert.Equal(`https://example.com/3`, nextLink(http.Header{
+		`Link`: []string{`<https://example.com/3>; title="more"; rel="last next"`},
+	}))
+
+	assert.Equal(``, nextLink(http.Header{
+		`Link`: []string{`</x?page=1>; rel="prev"`},
+	}))
+}
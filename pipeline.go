@@ -1,7 +1,12 @@
 package orchestra
 
 import (
+	"context"
 	"fmt"
+	"maps"
+	"slices"
+	"strings"
+	"sync"
 
 	"github.com/ghetzel/go-stockutil/log"
 	"github.com/ghetzel/go-stockutil/rxutil"
@@ -24,6 +29,7 @@ type Pipeline struct {
 	Summary  string          `yaml:"summary,omitempty"  json:"summary,omitempty"`
 	Required Context         `yaml:"required,omitempty" json:"required,omitempty"`
 	Steps    []*PipelineStep `yaml:"steps"              json:"steps"`
+	Timeout  string          `yaml:"timeout,omitempty"  json:"timeout,omitempty"`
 }
 
 func (pipeline *Pipeline) validateRules(queryResponse *QueryResponse, opts *QueryOptions) error {
@@ -77,87 +83,307 @@ func (pipeline *Pipeline) validateRules(queryResponse *QueryResponse, opts *Quer
 }
 
 func (pipeline *Pipeline) Query(opts *QueryOptions) (*QueryResponse, error) {
+	return pipeline.QueryWithContext(context.Background(), opts)
+}
+
+// QueryWithContext runs all steps of the pipeline, stopping early if ctx is done or the pipeline's
+// timeout elapses.  Each step starts as soon as the steps it depends on have completed, so
+// independent steps run concurrently.
+func (pipeline *Pipeline) QueryWithContext(ctx context.Context, opts *QueryOptions) (*QueryResponse, error) {
+	var pipelineCtx, cancel = withTimeout(ctx, pipeline.Timeout)
+	defer cancel()
+
 	var results = make(map[string]any)
+	var stepResults = make([]map[string]any, len(pipeline.Steps))
 	var queryResponse = NewQueryResponse(nil)
+	var done = make([]chan struct{}, len(pipeline.Steps))
+	var wg sync.WaitGroup
+	var lock sync.Mutex
+	var firstErr error
 
 	if opts == nil {
 		opts = new(QueryOptions)
 	}
 
+	var dependencies, err = pipeline.dependencies()
+
+	if err != nil {
+		return queryResponse.Failed(err)
+	}
+
+	var fail = func(err error) {
+		lock.Lock()
+		defer lock.Unlock()
+
+		if firstErr == nil {
+			firstErr = err
+			cancel()
+		}
+	}
+
+	var ancestors = transitiveDependencies(dependencies)
+
+	for i := range done {
+		done[i] = make(chan struct{})
+		stepResults[i] = make(map[string]any)
+	}
+
 	for i, step := range pipeline.Steps {
-		i = i + 1
+		wg.Add(1)
+
+		go func(i int, step *PipelineStep) {
+			defer wg.Done()
+			defer close(done[i])
+
+			for _, dep := range dependencies[i] {
+				select {
+				case <-done[dep]:
+				case <-pipelineCtx.Done():
+				}
+			}
+
+			if err := pipelineCtx.Err(); err != nil {
+				fail(fmt.Errorf("step %d: %v", i+1, err))
+				return
+			}
+
+			// $root only holds the results of the steps this one (transitively) depends on, so that
+			// it doesn't vary with whichever independent steps happen to have finished first
+			var root = make(map[string]any)
+
+			lock.Lock()
+
+			for _, j := range ancestors[i] {
+				maps.Copy(root, stepResults[j])
+			}
 
-		if step.Query == nil {
-			step.Query = new(QueryOptions)
+			lock.Unlock()
+
+			if err := pipeline.runStep(pipelineCtx, i+1, step, opts, root, func(k string, v any) {
+				lock.Lock()
+				defer lock.Unlock()
+
+				results[k] = v
+				stepResults[i][k] = v
+			}, func(merged *QueryOptions) error {
+				lock.Lock()
+				defer lock.Unlock()
+
+				return pipeline.validateRules(queryResponse, merged)
+			}); err != nil {
+				fail(err)
+			}
+		}(i, step)
+	}
+
+	wg.Wait()
+
+	if firstErr != nil {
+		return queryResponse.Failed(firstErr)
+	}
+
+	for _, step := range pipeline.Steps {
+		if step.OmitOutput {
+			delete(results, step.key())
 		}
+	}
+
+	return queryResponse.Completed(results)
+}
+
+// runStep merges the pipeline, caller, and step query options and retrieves the step's result,
+// handing it (and its context, if requested) to store.
+func (pipeline *Pipeline) runStep(
+	ctx context.Context,
+	i int,
+	step *PipelineStep,
+	opts *QueryOptions,
+	root map[string]any,
+	store func(key string, value any),
+	validate func(merged *QueryOptions) error,
+) error {
+	var key = step.key()
+	var stepQuery = step.Query
 
-		var key string
+	if stepQuery == nil {
+		stepQuery = new(QueryOptions)
+	}
+
+	var merged = NewQueryOptions()
+
+	if m, err := opts.Merge(&QueryOptions{
+		Context: pipeline.Context,
+	}); err == nil {
+		merged = m
+	} else {
+		return fmt.Errorf("step %d [%s]: %v", i, key, err)
+	}
+
+	if m, err := merged.Merge(opts); err == nil {
+		merged = m
+	} else {
+		return fmt.Errorf("step %d [%s]: %v", i, key, err)
+	}
 
-		// figure out which key in the response the data goes to
-		if step.ResultTarget != `` {
-			key = step.ResultTarget
-		} else if key == `` && step.Query != nil && step.Query.UseEndpoint != `` {
-			key = step.Query.UseEndpoint
-		} else {
-			key = DefaultResultKey
+	if m, err := merged.Merge(stepQuery); err == nil {
+		merged = m
+	} else {
+		return fmt.Errorf("step %d [%s]: %v", i, key, err)
+	}
+
+	merged.Variables[RootVarName] = root
+
+	if err := validate(merged); err != nil {
+		return err
+	}
+
+	if step.SkipStep {
+		return nil
+	} else if run, reason, err := step.shouldRun(root, merged.Variables); err != nil {
+		if step.Optional {
+			debugf("step %d [%s]: %v", i, key, err)
+			store(key, nil)
+			return nil
 		}
 
-		var merged = NewQueryOptions()
+		return fmt.Errorf("step %d [%s]: %v", i, key, err)
+	} else if !run {
+		debugf("step %d [%s]: skipped, %s", i, key, reason)
 
-		if m, err := opts.Merge(&QueryOptions{
-			Context: pipeline.Context,
-		}); err == nil {
-			merged = m
-		} else {
-			return queryResponse.Failedf("step %d [%s]: %v", i, key, err)
+		if step.WithContext {
+			store(DefaultContextPrefix+key, QueryContext{
+				`skipped`: true,
+				`reason`:  reason,
+			})
 		}
 
-		if m, err := merged.Merge(opts); err == nil {
-			merged = m
-		} else {
-			return queryResponse.Failedf("step %d [%s]: %v", i, key, err)
+		return nil
+	} else if result, stepContext, err := step.RetrieveWithContext(ctx, merged, root); err == nil {
+		store(key, result)
+
+		if step.WithContext {
+			store(DefaultContextPrefix+key, stepContext)
+		}
+	} else if step.Optional {
+		store(key, nil)
+
+		if step.WithContext {
+			store(DefaultContextPrefix+key, stepContext)
+		}
+
+		debugf("step %d [%s]: %v", i, key, err)
+	} else {
+		return fmt.Errorf("step %d [%s]: %v", i, key, err)
+	}
+
+	return nil
+}
+
+// dependencies returns, for each step, the indices of the steps it must wait for.  If no step
+// declares depends_on, every step depends on the one before it and the pipeline runs in order.
+// Otherwise steps without depends_on can start right away.  Unknown step references and dependency
+// cycles are reported as errors.
+func (pipeline *Pipeline) dependencies() ([][]int, error) {
+	var deps = make([][]int, len(pipeline.Steps))
+	var index = make(map[string][]int)
+	var explicit bool
+
+	for i, step := range pipeline.Steps {
+		if len(step.DependsOn) > 0 {
+			explicit = true
 		}
 
-		if m, err := merged.Merge(step.Query); err == nil {
-			merged = m
-		} else {
-			return queryResponse.Failedf("step %d [%s]: %v", i, key, err)
+		if step.Name != `` {
+			index[step.Name] = append(index[step.Name], i)
 		}
 
-		merged.Variables[RootVarName] = results
+		if key := step.key(); key != step.Name {
+			index[key] = append(index[key], i)
+		}
+	}
 
-		if err := pipeline.validateRules(queryResponse, merged); err != nil {
-			return queryResponse.Failed(err)
+	if !explicit {
+		for i := 1; i < len(deps); i++ {
+			deps[i] = []int{i - 1}
 		}
 
-		if step.SkipStep {
-			continue
-		} else if result, ctx, err := step.Retrieve(merged, results); err == nil {
-			step.ResultTarget = key
+		return deps, nil
+	}
 
-			results[key] = result
+	for i, step := range pipeline.Steps {
+		for _, name := range step.DependsOn {
+			if targets, ok := index[name]; ok {
+				for _, j := range targets {
+					if j == i {
+						return nil, fmt.Errorf("step %d [%s]: cannot depend on itself", i+1, step.key())
+					}
 
-			if step.WithContext {
-				results[DefaultContextPrefix+key] = ctx
+					deps[i] = append(deps[i], j)
+				}
+			} else {
+				return nil, fmt.Errorf("step %d [%s]: depends on undefined step %q", i+1, step.key(), name)
 			}
-		} else if step.Optional {
-			results[key] = nil
-			if step.WithContext {
-				results[DefaultContextPrefix+key] = ctx
+		}
+	}
+
+	// depth-first search for cycles
+	var state = make([]int, len(deps))
+	var path []string
+	var visit func(i int) error
+
+	visit = func(i int) error {
+		path = append(path, pipeline.Steps[i].key())
+		defer func() { path = path[:len(path)-1] }()
+
+		switch state[i] {
+		case 1:
+			return fmt.Errorf("dependency cycle: %s", strings.Join(path, ` -> `))
+		case 2:
+			return nil
+		}
+
+		state[i] = 1
+
+		for _, j := range deps[i] {
+			if err := visit(j); err != nil {
+				return err
 			}
+		}
+
+		state[i] = 2
+		return nil
+	}
 
-			log.Debugf("step %d [%s]: %v", i, key, err)
-			continue
-		} else {
-			return queryResponse.Failedf("step %d [%s]: %v", i, key, err)
+	for i := range deps {
+		if err := visit(i); err != nil {
+			return nil, err
 		}
 	}
 
-	for _, step := range pipeline.Steps {
-		if step.OmitOutput {
-			delete(results, step.ResultTarget)
+	return deps, nil
+}
+
+// transitiveDependencies returns, for each step, the (ascending) indices of every step it depends on
+// either directly or through another step.
+func transitiveDependencies(deps [][]int) [][]int {
+	var closure = make([][]int, len(deps))
+
+	for i := range deps {
+		var seen = make(map[int]bool)
+		var pending = slices.Clone(deps[i])
+
+		for len(pending) > 0 {
+			var j = pending[len(pending)-1]
+			pending = pending[:len(pending)-1]
+
+			if !seen[j] {
+				seen[j] = true
+				pending = append(pending, deps[j]...)
+			}
 		}
+
+		closure[i] = slices.Sorted(maps.Keys(seen))
 	}
 
-	return queryResponse.Completed(results)
+	return closure
 }
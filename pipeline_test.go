@@ -0,0 +1,287 @@
+package orchestra
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/ghetzel/go-stockutil/httputil"
+	"github.com/ghetzel/testify/require"
+)
+
+func TestPipelineDependencies(t *testing.T) {
+	var assert = require.New(t)
+
+	var sequential = &Pipeline{
+		Steps: []*PipelineStep{
+			{ResultTarget: `a`},
+			{ResultTarget: `b`},
+			{ResultTarget: `c`},
+		},
+	}
+
+	var deps, err = sequential.dependencies()
+	assert.NoError(err)
+	assert.Equal([][]int{nil, {0}, {1}}, deps)
+
+	var dag = &Pipeline{
+		Steps: []*PipelineStep{
+			{ResultTarget: `a`},
+			{Name: `second`, ResultTarget: `b`},
+			{ResultTarget: `c`, DependsOn: []string{`a`, `second`}},
+		},
+	}
+
+	deps, err = dag.dependencies()
+	assert.NoError(err)
+	assert.Equal([][]int{nil, nil, {0, 1}}, deps)
+
+	assert.Equal([][]int{nil, {0}, {0, 1}}, transitiveDependencies([][]int{nil, {0}, {1}}))
+	assert.Equal([][]int{nil, nil, {0, 1}, {0, 1, 2}}, transitiveDependencies([][]int{nil, nil, {0, 1}, {2}}))
+
+	dag.Steps[0].DependsOn = []string{`c`}
+	_, err = dag.dependencies()
+	assert.Error(err)
+	assert.Contains(err.Error(), `dependency cycle`)
+
+	dag.Steps[0].DependsOn = []string{`nope`}
+	_, err = dag.dependencies()
+	assert.Error(err)
+	assert.Contains(err.Error(), `undefined step "nope"`)
+}
+
+func TestPipelineConcurrentBranches(t *testing.T) {
+	var assert = require.New(t)
+
+	var arrived int32
+	var overlapping = make(chan struct{})
+
+	// neither branch is answered until both are in flight at once
+	var server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&arrived, 1) == 2 {
+			close(overlapping)
+		}
+
+		select {
+		case <-overlapping:
+			httputil.RespondJSON(w, r.URL.Path)
+		case <-time.After(5 * time.Second):
+			http.Error(w, `branches did not run concurrently`, http.StatusConflict)
+		}
+	}))
+	defer server.Close()
+
+	RegisterEndpoint(`dag-slow`, &Endpoint{
+		URL: server.URL + `/{{ .vars.which }}`,
+	})
+
+	var schema = &Schema{
+		Name: `test-dag`,
+		Pipeline: &Pipeline{
+			Steps: []*PipelineStep{
+				{
+					ResultTarget: `left`,
+					Query: &QueryOptions{
+						UseEndpoint: `dag-slow`,
+						Context: Context{
+							Variables: map[string]any{`which`: `left`},
+						},
+					},
+				}, {
+					ResultTarget: `right`,
+					Query: &QueryOptions{
+						UseEndpoint: `dag-slow`,
+						Context: Context{
+							Variables: map[string]any{`which`: `right`},
+						},
+					},
+				}, {
+					ResultTarget: `both`,
+					DependsOn:    []string{`left`, `right`},
+					Transforms: []any{
+						`[left, right]`,
+					},
+				},
+			},
+		},
+	}
+
+	var response, err = schema.Query(nil)
+	assert.NoError(err)
+
+	var results, ok = response.Result.(map[string]any)
+	assert.True(ok)
+	assert.Equal(`/left`, results[`left`])
+	assert.Equal(`/right`, results[`right`])
+	assert.Equal([]any{`/left`, `/right`}, results[`both`])
+}
+
+func TestPipelineRootScopedToDependencies(t *testing.T) {
+	var assert = require.New(t)
+
+	var schema = &Schema{
+		Name: `test-dag-root`,
+		Pipeline: &Pipeline{
+			Steps: []*PipelineStep{
+				{
+					ResultTarget: `a`,
+					Transforms:   []any{`1`},
+				}, {
+					ResultTarget: `b`,
+					Transforms:   []any{`2`},
+				}, {
+					ResultTarget: `c`,
+					DependsOn:    []string{`a`},
+					Transforms:   []any{`$keys($)`},
+				}, {
+					ResultTarget: `d`,
+					DependsOn:    []string{`b`, `c`},
+					Transforms:   []any{`$sort($keys($))`},
+				},
+			},
+		},
+	}
+
+	// c never sees b, whether or not it has finished, but d sees everything upstream of it
+	for range 20 {
+		var response, err = schema.Query(nil)
+		assert.NoError(err)
+
+		var results = response.Result.(map[string]any)
+		assert.Equal(`a`, results[`c`])
+		assert.Equal([]any{`a`, `b`, `c`}, results[`d`])
+	}
+}
+
+func TestLoadDatasetsRejectsCycles(t *testing.T) {
+	var assert = require.New(t)
+	var datasets = NewConfig().Datasets
+
+	datasets.Queries[`cyclic`] = &Schema{
+		Pipeline: &Pipeline{
+			Steps: []*PipelineStep{
+				{ResultTarget: `a`, DependsOn: []string{`b`}},
+				{ResultTarget: `b`, DependsOn: []string{`a`}},
+			},
+		},
+	}
+
+	var err = loadDatasets(datasets)
+	assert.Error(err)
+	assert.Contains(err.Error(), `dependency cycle`)
+}
+
+func TestLoadDatasetsRejectsBadTimeouts(t *testing.T) {
+	var assert = require.New(t)
+
+	for _, timeout := range []string{`5`, `soon`, `-1s`, `0s`} {
+		var datasets = NewConfig().Datasets
+
+		datasets.Endpoints[`timeout-test`] = &Endpoint{
+			URL:     `http://localhost`,
+			Timeout: timeout,
+		}
+
+		var err = loadDatasets(datasets)
+		assert.Error(err, timeout)
+		assert.Contains(err.Error(), `endpoint timeout-test: timeout must be a positive duration`)
+
+		datasets = NewConfig().Datasets
+		datasets.Queries[`timeout-test`] = &Schema{
+			Pipeline: &Pipeline{
+				Timeout: timeout,
+			},
+		}
+
+		err = loadDatasets(datasets)
+		assert.Error(err, timeout)
+		assert.Contains(err.Error(), `query timeout-test: timeout must be a positive duration`)
+
+		datasets.Queries[`timeout-test`].Pipeline = &Pipeline{
+			Steps: []*PipelineStep{
+				{ResultTarget: `a`, Timeout: timeout},
+			},
+		}
+
+		err = loadDatasets(datasets)
+		assert.Error(err, timeout)
+		assert.Contains(err.Error(), `query timeout-test, step 0: timeout must be a positive duration`)
+	}
+
+	var datasets = NewConfig().Datasets
+
+	datasets.Queries[`timeout-test`] = &Schema{
+		Pipeline: &Pipeline{
+			Timeout: `1m30s`,
+			Steps: []*PipelineStep{
+				{ResultTarget: `a`, Timeout: `500ms`},
+			},
+		},
+	}
+
+	assert.NoError(loadDatasets(datasets))
+}
+
+func TestPipelineWhenGuards(t *testing.T) {
+	var assert = require.New(t)
+
+	var schema = &Schema{
+		Name: `test-when`,
+		Pipeline: &Pipeline{
+			Steps: []*PipelineStep{
+				{
+					ResultTarget: `count`,
+					Transforms:   []any{`3`},
+				}, {
+					ResultTarget: `many`,
+					When:         `count > 2`,
+					Transforms:   []any{`"yes"`},
+				}, {
+					ResultTarget: `few`,
+					When:         `$root.count < 2`,
+					WithContext:  true,
+					Transforms:   []any{`"yes"`},
+				}, {
+					ResultTarget: `flagged`,
+					When:         `$flag`,
+					WithContext:  true,
+					Transforms:   []any{`"flagged"`},
+				}, {
+					ResultTarget: `after`,
+					Transforms:   []any{`$exists(few) ? "ran" : "skipped"`},
+				},
+			},
+		},
+	}
+
+	var response, err = schema.Query(nil)
+	assert.NoError(err)
+
+	var results = response.Result.(map[string]any)
+	assert.Equal(`yes`, results[`many`])
+	assert.NotContains(results, `few`)
+	assert.NotContains(results, `flagged`)
+	assert.Equal(`skipped`, results[`after`])
+
+	var fewContext, ok = results[`context_few`].(QueryContext)
+	assert.True(ok)
+	assert.Equal(true, fewContext[`skipped`])
+	assert.Contains(fewContext[`reason`], `is false`)
+
+	var flaggedContext QueryContext
+	flaggedContext, ok = results[`context_flagged`].(QueryContext)
+	assert.True(ok)
+	assert.Contains(flaggedContext[`reason`], `is undefined`)
+
+	response, err = schema.Query(&QueryOptions{
+		Context: Context{
+			Variables: map[string]any{`flag`: true},
+		},
+	})
+	assert.NoError(err)
+
+	results = response.Result.(map[string]any)
+	assert.Equal(`flagged`, results[`flagged`])
+}
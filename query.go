@@ -1,7 +1,9 @@
 package orchestra
 
 import (
+	"context"
 	"fmt"
+	"net/http"
 	"net/url"
 	"strings"
 	"sync"
@@ -23,6 +25,10 @@ type QueryOptions struct {
 	VariablesQuery  any            `yaml:"variables_json,omitempty"   json:"variables_json,omitempty"`
 	Transforms      []any          `yaml:"transforms,omitempty"       json:"transforms,omitempty"`
 	UseEndpoint     string         `yaml:"endpoint,omitempty"         json:"endpoint,omitempty"`
+	Schema          string         `yaml:"schema,omitempty"           json:"schema,omitempty"`
+	Body            any            `yaml:"body,omitempty"             json:"body,omitempty"`
+	BodyQuery       any            `yaml:"body_json,omitempty"        json:"body_json,omitempty"`
+	bodyQueried     bool
 }
 
 func NewQueryOptions() *QueryOptions {
@@ -63,9 +69,31 @@ func (opts *QueryOptions) Render(data any) (*QueryOptions, error) {
 		return nil, err
 	}
 
+	if value, err := opts.RenderBody(data); err == nil {
+		rq.Body = value
+		rq.bodyQueried = opts.bodyQueried || !typeutil.IsZero(opts.BodyQuery)
+		rq.BodyQuery = nil
+	} else {
+		return nil, err
+	}
+
 	return &rq, nil
 }
 
+// RenderBody returns the result of the body_json query against data, if one is set, or the
+// explicit body otherwise.
+func (opts *QueryOptions) RenderBody(data any) (any, error) {
+	if !typeutil.IsZero(opts.BodyQuery) {
+		if value, err := applyJsonata(data, opts.Variables, opts.BodyQuery); err == nil {
+			return value, nil
+		} else {
+			return nil, fmt.Errorf("body: %v", err)
+		}
+	}
+
+	return opts.Body, nil
+}
+
 func (opts *QueryOptions) RenderPathParams(data any) (map[string]any, error) {
 	return opts.renderValuesFor(`path_params`, data)
 }
@@ -146,6 +174,9 @@ func (query *QueryOptions) Merge(other *QueryOptions) (*QueryOptions, error) {
 		if v := qo.UseEndpoint; v != `` {
 			result.UseEndpoint = v
 		}
+		if v := qo.Schema; v != `` {
+			result.Schema = v
+		}
 		if v := qo.PathParamsQuery; v != `` {
 			result.PathParamsQuery = v
 		}
@@ -158,6 +189,12 @@ func (query *QueryOptions) Merge(other *QueryOptions) (*QueryOptions, error) {
 		if v := qo.VariablesQuery; v != `` {
 			result.VariablesQuery = v
 		}
+		if v := qo.Body; v != nil {
+			result.Body = v
+		}
+		if v := qo.BodyQuery; v != nil {
+			result.BodyQuery = v
+		}
 		if v := qo.Transforms; len(v) > 0 {
 			result.Transforms = v
 		}
@@ -186,40 +223,33 @@ func (query *QueryOptions) Merge(other *QueryOptions) (*QueryOptions, error) {
 	return result, nil
 }
 
+// QueryConcurrent performs the query and marks wg as done when it completes.
+//
+// Deprecated: this call blocks until the query completes.  Parallel foreach steps fan out on their
+// own; see PipelineStep.Concurrency.
 func (query *QueryOptions) QueryConcurrent(wg *sync.WaitGroup, endpoint *Endpoint) (*QueryResponse, error) {
 	if wg != nil {
-		defer wg.Done()
 		wg.Add(1)
+		defer wg.Done()
 	}
 
-	var results = make(chan any)
-
-	go func() {
-		if r, err := query.Query(endpoint); err == nil {
-			results <- r
-		} else {
-			results <- err
-		}
-	}()
-
-	for res := range results {
-		if r, ok := res.(*QueryResponse); ok {
-			return r, nil
-		} else if err := res.(error); ok {
-			return nil, err
-		} else {
-			return nil, fmt.Errorf("invalid response from goroutine (%T)", res)
-		}
-	}
-
-	return nil, fmt.Errorf("no response from goroutine")
+	return query.Query(endpoint)
 }
 
 func (query *QueryOptions) Query(endpoint *Endpoint) (*QueryResponse, error) {
+	return query.QueryWithContext(context.Background(), endpoint)
+}
+
+// QueryWithContext performs the query against the given endpoint.  Any in-flight requests are
+// canceled when ctx is done or the endpoint's timeout elapses, whichever comes first.
+func (query *QueryOptions) QueryWithContext(ctx context.Context, endpoint *Endpoint) (*QueryResponse, error) {
 	var queryResponse = NewQueryResponse(endpoint)
 	var headers = make(map[string]any)
 	var params = make(map[string]any)
 	var vars = make(map[string]any)
+	var configHeaders = make(map[string]bool)
+	var configParams = make(map[string]bool)
+	var sensitive = make(map[string]bool)
 
 	if query == nil {
 		query = new(QueryOptions)
@@ -227,12 +257,14 @@ func (query *QueryOptions) Query(endpoint *Endpoint) (*QueryResponse, error) {
 
 	// endpoint-specific headers
 	for k, v := range endpoint.Headers {
-		headers[k] = v
+		headers[k] = unwrapSensitive(k, v, sensitive)
+		configHeaders[k] = true
 	}
 
 	// endpoint-specific params
 	for k, v := range endpoint.Params {
-		params[k] = v
+		params[k] = unwrapSensitive(k, v, sensitive)
+		configParams[k] = true
 	}
 
 	// endpoint-specific variables
@@ -242,12 +274,14 @@ func (query *QueryOptions) Query(endpoint *Endpoint) (*QueryResponse, error) {
 
 	// query-specific headers (overrides endpoint)
 	for k, v := range query.Headers {
-		headers[k] = v
+		headers[k] = unwrapSensitive(k, v, sensitive)
+		delete(configHeaders, k)
 	}
 
 	// query-specific params (overrides endpoint)
 	for k, v := range query.Params {
-		params[k] = v
+		params[k] = unwrapSensitive(k, v, sensitive)
+		delete(configParams, k)
 	}
 
 	// query-specific variables (overrides endpoint)
@@ -255,44 +289,154 @@ func (query *QueryOptions) Query(endpoint *Endpoint) (*QueryResponse, error) {
 		vars[k] = v
 	}
 
+	queryResponse.sensitive = sensitive
 	queryResponse.Context = map[string]any{
 		`vars`:    vars,
 		`params`:  params,
 		`headers`: headers,
 	}
 
-	if _, err := query.retrieveViaURL(endpoint, queryResponse, headers, params, vars); err != nil {
-		return queryResponse, err
+	var timeoutCtx, cancel = withTimeout(ctx, endpoint.Timeout)
+	defer cancel()
+
+	var request = &Request{
+		URL:     FormatString(endpoint.URL, queryResponse.Context),
+		Headers: headers,
+		Params:  params,
+		Vars:    vars,
+		Query:   query,
+		Context: queryResponse.Context,
+
+		configHeaders: configHeaders,
+		configParams:  configParams,
+		sensitive:     sensitive,
+	}
+
+	if transport, err := endpoint.transport(request.URL); err == nil {
+		if out, err := transport.Do(timeoutCtx, endpoint, request); err == nil {
+			if filtered, err := query.filterResult(endpoint, out, vars); err == nil {
+				queryResponse.Result = filtered
+			} else {
+				return queryResponse.Failed(err)
+			}
+		} else {
+			return queryResponse.Failed(err)
+		}
+	} else {
+		return queryResponse.Failed(err)
 	}
 
 	return queryResponse.Completed(nil)
 }
 
-func (query *QueryOptions) retrieveViaURL(
-	endpoint *Endpoint,
-	queryResponse *QueryResponse,
-	headers map[string]any,
-	params map[string]any,
-	vars map[string]any,
-) (*QueryResponse, error) {
-	// interpolate any fields in the URL
-	var fmturl = FormatString(endpoint.URL, queryResponse.Context)
+// staticBody returns the body from configuration to send, if the body doesn't come from a body_json
+// query: the query's body takes precedence over the endpoint's.
+func (query *QueryOptions) staticBody(endpoint *Endpoint) (any, bool) {
+	if query.bodyQueried || !typeutil.IsZero(query.BodyQuery) {
+		return nil, false
+	} else if query.Body != nil {
+		return query.Body, true
+	} else if !typeutil.IsZero(endpoint.RequestBodyQuery) {
+		return nil, false
+	}
+
+	return endpoint.RequestBody, true
+}
+
+// renderRequestBody determines the body to send to the endpoint: the query's body or body_json
+// query takes precedence, followed by the endpoint's.  A static body is rendered as a template
+// (using the same data as the endpoint URL), in which case static is true; the output of body_json
+// queries is sent as-is, since it may hold data from variables or other steps' results.
+func (query *QueryOptions) renderRequestBody(endpoint *Endpoint, data map[string]any, vars map[string]any) (any, bool, error) {
+	if body, ok := query.staticBody(endpoint); ok {
+		return renderTemplates(body, data), true, nil
+	} else if query.bodyQueried || !typeutil.IsZero(query.BodyQuery) {
+		var body, err = query.RenderBody(data)
+		return body, false, err
+	} else if body, err := applyJsonata(data, vars, endpoint.RequestBodyQuery); err == nil {
+		return body, false, nil
+	} else {
+		return nil, false, fmt.Errorf("body: %v", err)
+	}
+}
+
+// filterResult applies the endpoint's result filters, then the query's transforms, to a retrieved
+// result.
+func (query *QueryOptions) filterResult(endpoint *Endpoint, out any, vars map[string]any) (any, error) {
+	// apply endpoint-level filters first
+	if filtered, err := applyJsonata(
+		out,
+		vars,
+		endpoint.ResultFilters...,
+	); err == nil {
+		out = filtered
+	} else {
+		return nil, err
+	}
+
+	// apply query-level filters next
+	return applyJsonata(
+		out,
+		vars,
+		query.Transforms...,
+	)
+}
+
+// retrieveViaURL is the transport for HTTP endpoints.
+func retrieveViaURL(ctx context.Context, endpoint *Endpoint, request *Request) (any, error) {
+	var headers = request.Headers
+	var params = request.Params
+	var vars = request.Vars
+	var auth, err = endpoint.Auth.resolve()
+
+	if err != nil {
+		return nil, err
+	}
+
+	var rawURL string
+
+	if u, err := request.ResolveString(ctx, endpoint.URL); err == nil {
+		rawURL = u
+	} else {
+		return nil, err
+	}
 
 	// parse interpolated URL into url.URL to validate it
-	if endpointURL, err := url.Parse(fmturl); err == nil {
+	if endpointURL, err := url.Parse(rawURL); err == nil {
 		if client, err := httputil.NewClient(endpointURL.String()); err == nil {
-			if endpoint.Method == `` {
-				if endpoint.GraphQL != nil {
-					endpoint.Method = `POST`
+			var method = httputil.Method(strings.ToUpper(endpoint.Method))
+
+			if hc, err := endpoint.httpClient(ctx); err == nil {
+				client.SetClient(hc)
+			} else {
+				return nil, err
+			}
+
+			if endpoint.GraphQL == nil {
+				if encoder, err := bodyEncoder(endpoint.BodyEncoding); err == nil {
+					client.SetEncoder(encoder)
 				} else {
-					endpoint.Method = `GET`
+					return nil, err
 				}
 			}
 
-			var method = httputil.Method(strings.ToUpper(endpoint.Method))
+			// signatures cover the final request, so are added just before it is sent
+			if auth != nil && auth.signs() {
+				client.SetPreRequestHook(func(req *http.Request) (any, error) {
+					return nil, auth.sign(req)
+				})
+			}
+
+			if method == `` {
+				if endpoint.GraphQL != nil {
+					method = httputil.Post
+				} else {
+					method = httputil.Get
+				}
+			}
 
 			// perform the HTTP request
-			// log.Debugf("orchestra/endpoint[%s] %s %v params=%+v headers=%+v vars=%+v", endpoint.Name, method, endpointURL, params, headers, vars)
+			debugf("orchestra/endpoint[%s] %s %v params=%+v headers=%+v vars=%+v", endpoint.Name, method, request.URL, redactNames(params, request.sensitive), redactNames(headers, request.sensitive), vars)
 			var body any
 
 			if gql := endpoint.GraphQL; gql != nil {
@@ -303,54 +447,159 @@ func (query *QueryOptions) retrieveViaURL(
 						`variables`: vars,
 					}
 
-					queryResponse.Context[`graphql`] = gq
+					request.Context[`graphql`] = gq
 					body = gq
 				} else {
-					return queryResponse.Failed(err)
+					return nil, err
 				}
+			} else if b, err := request.Body(ctx, endpoint); err == nil {
+				body = b
 			} else {
-				body = endpoint.RequestBody
+				return nil, err
 			}
 
-			if response, err := client.Request(method, "", body, params, headers); err == nil {
-				var out any
-
-				if err := client.Decode(response.Body, &out); err == nil {
-					// apply endpoint-level filters first
-					if filtered, err := applyJsonata(
-						out,
-						vars,
-						endpoint.ResultFilters...,
-					); err == nil {
-						out = filtered
-					} else {
-						return queryResponse.Failed(err)
+			// retrieves and decodes the response (or all pages of it), recording details in meta
+			var load = func(ctx context.Context, meta QueryContext) (any, error) {
+				var attempts int
+
+				var notModified int
+
+				var fetch pageFetchFunc = func(path string, pageParams map[string]any) (*http.Response, any, error) {
+					var reqHeaders = headers
+					var condKey string
+
+					// send validators from the last response to this exact request, if we have any
+					if endpoint.conditional(method) {
+						condKey = requestKey(map[string]any{
+							`endpoint`: endpoint.Name,
+							`method`:   method,
+							`url`:      endpointURL.String(),
+							`path`:     path,
+							`params`:   pageParams,
+							`headers`:  headers,
+						})
+
+						reqHeaders = withValidators(condKey, headers)
+					}
+
+					var send = func() (*http.Response, map[string]any, int, error) {
+						var sendHeaders map[string]any
+						var sendParams map[string]any
+
+						if h, err := request.ResolveHeaders(ctx, reqHeaders); err == nil {
+							sendHeaders = h
+						} else {
+							return nil, nil, 0, err
+						}
+
+						if p, err := request.ResolveParams(ctx, pageParams); err == nil {
+							sendParams = p
+						} else {
+							return nil, nil, 0, err
+						}
+
+						if auth != nil {
+							if h, err := auth.authorize(ctx, endpoint, sendHeaders); err == nil {
+								sendHeaders = h
+							} else {
+								return nil, nil, 0, err
+							}
+						}
+
+						var response, tries, err = endpoint.Retry.do(ctx, string(method), func() (*http.Response, error) {
+							return client.RequestWithContext(ctx, method, path, body, sendParams, sendHeaders)
+						})
+
+						return response, sendHeaders, tries, err
+					}
+
+					var response, sentHeaders, tries, err = send()
+
+					// a rejected token is discarded and the request retried (once) with a new one
+					if auth != nil && response != nil && response.StatusCode == http.StatusUnauthorized {
+						response.Body.Close()
+						auth.invalidate(sentHeaders)
+						attempts += tries
+
+						response, _, tries, err = send()
 					}
 
-					// apply query-level filters next
-					if filtered, err := applyJsonata(
-						out,
-						vars,
-						query.Transforms...,
-					); err == nil {
-						out = filtered
+					attempts += tries
+					meta[`attempts`] = attempts
+
+					if err == nil {
+						if condKey != `` && response.StatusCode == http.StatusNotModified {
+							response.Body.Close()
+
+							if cond, ok := lookupConditional(condKey); ok {
+								notModified += 1
+								meta[`not_modified`] = notModified
+
+								return response, cond.Body, nil
+							} else {
+								return response, nil, fmt.Errorf("HTTP %v: no previous response to reuse", response.Status)
+							}
+						} else if out, err := endpoint.decode(response); err == nil {
+							if condKey != `` {
+								rememberValidators(condKey, response, out)
+							}
+
+							return response, out, nil
+						} else {
+							return response, nil, err
+						}
 					} else {
-						return queryResponse.Failed(err)
+						if response != nil {
+							response.Body.Close()
+						}
+
+						return response, nil, err
 					}
+				}
 
-					queryResponse.Result = out
+				if pager := endpoint.Pagination; pager != nil {
+					if items, pages, err := pager.retrieve(fetch, params, vars); err == nil {
+						meta[`pages`] = pages
+						return items, nil
+					} else {
+						return nil, err
+					}
 				} else {
-					return queryResponse.Failed(err)
+					var _, out, err = fetch(``, params)
+					return out, err
 				}
+			}
+
+			var fingerprint = map[string]any{
+				`method`:  method,
+				`url`:     endpointURL.String(),
+				`params`:  params,
+				`headers`: headers,
+				`body`:    body,
+			}
+
+			// pagination expressions can refer to variables that aren't part of the request itself
+			if endpoint.Pagination != nil {
+				fingerprint[`vars`] = vars
+			}
+
+			// identical requests made at the same time share a single upstream call
+			var coalesced = func(ctx context.Context, meta QueryContext) (any, error) {
+				return inflightRequests.do(ctx, requestKey(map[string]any{
+					`endpoint`: endpoint.Name,
+					`request`:  fingerprint,
+				}), load, meta)
+			}
+
+			if cache := endpoint.Cache; cache != nil {
+				return cache.retrieve(ctx, endpoint, cache.key(endpoint, fingerprint), coalesced, request.Context)
 			} else {
-				return queryResponse.Failed(err)
+				return coalesced(ctx, request.Context)
 			}
 		} else {
-			return queryResponse.Failed(err)
+			return nil, err
 		}
 	} else {
-		return queryResponse.Failed(err)
+		return nil, err
 	}
-
-	return queryResponse, nil
 }
@@ -1,8 +1,16 @@
 package orchestra
 
 import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
 	"testing"
+	"time"
 
+	"github.com/ghetzel/go-stockutil/httputil"
+	"github.com/ghetzel/go-stockutil/typeutil"
 	"github.com/ghetzel/testify/require"
 )
 
@@ -45,3 +53,146 @@ func TestQueryMerge(t *testing.T) {
 		`X-Cool-Other`: `1`,
 	}, result.Headers)
 }
+
+func TestQueryEndpointTimeout(t *testing.T) {
+	var assert = require.New(t)
+	var server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-r.Context().Done():
+		case <-time.After(5 * time.Second):
+		}
+
+		httputil.RespondJSON(w, true)
+	}))
+	defer server.Close()
+
+	var started = time.Now()
+	var _, err = NewQueryOptions().Query(&Endpoint{
+		URL:     server.URL,
+		Timeout: `50ms`,
+	})
+
+	assert.Error(err)
+	assert.True(time.Since(started) < 2*time.Second)
+}
+
+func TestQueryContextCanceled(t *testing.T) {
+	var assert = require.New(t)
+	var ctx, cancel = context.WithCancel(context.Background())
+	cancel()
+
+	var _, err = NewQueryOptions().QueryWithContext(ctx, &Endpoint{
+		URL: TestServer.URL + `/test/v1/services/`,
+	})
+
+	assert.Error(err)
+	assert.Contains(err.Error(), `context canceled`)
+}
+
+var TestEchoServer = func() *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var body, _ = io.ReadAll(r.Body)
+
+		httputil.RespondJSON(w, map[string]any{
+			`method`:       r.Method,
+			`content_type`: r.Header.Get(`Content-Type`),
+			`query`:        r.URL.RawQuery,
+			`body`:         string(body),
+		})
+	}))
+}()
+
+func TestQueryTemplatedBody(t *testing.T) {
+	var assert = require.New(t)
+
+	var endpoint = &Endpoint{
+		Method: `POST`,
+		URL:    TestEchoServer.URL,
+		RequestBody: map[string]any{
+			`search`: `{{ .vars.term }}`,
+			`limit`:  10,
+			`tags`:   []any{`{{ .params.tag }}`, `static`},
+		},
+		Params: map[string]any{
+			`tag`: `a&b`,
+		},
+		Variables: map[string]any{
+			`term`: `say "hi"`,
+		},
+		ResultFilters: []any{`body`},
+	}
+
+	var sent = func(response *QueryResponse) (out any) {
+		assert.NoError(json.Unmarshal([]byte(typeutil.String(response.Result)), &out))
+		return
+	}
+
+	var response, err = NewQueryOptions().Query(endpoint)
+	assert.NoError(err)
+	assert.Equal(map[string]any{
+		`search`: `say "hi"`,
+		`limit`:  float64(10),
+		`tags`:   []any{`a&b`, `static`},
+	}, sent(response))
+
+	endpoint.RequestBodyQuery = `{"search": $term, "n": 3}`
+
+	response, err = NewQueryOptions().Query(endpoint)
+	assert.NoError(err)
+	assert.Equal(map[string]any{
+		`search`: `say "hi"`,
+		`n`:      float64(3),
+	}, sent(response))
+
+	// query results aren't rendered again, so templates within variables are sent as-is
+	var opts = NewQueryOptions()
+	opts.Variables[`term`] = `{{ .params.tag }}`
+
+	response, err = opts.Query(endpoint)
+	assert.NoError(err)
+	assert.Equal(map[string]any{
+		`search`: `{{ .params.tag }}`,
+		`n`:      float64(3),
+	}, sent(response))
+
+	// a query's own static body is rendered in place of the endpoint's
+	opts = NewQueryOptions()
+	opts.Body = `{{ .vars.term }}!`
+
+	response, err = opts.Query(endpoint)
+	assert.NoError(err)
+	assert.Equal(`say "hi"!`, sent(response))
+
+	// ...but the output of its body_json query is not
+	opts = NewQueryOptions()
+	opts.BodyQuery = `{"search": "{{ .vars.term }}"}`
+
+	var rendered *QueryOptions
+	rendered, err = opts.Render(map[string]any{})
+	assert.NoError(err)
+
+	response, err = rendered.Query(endpoint)
+	assert.NoError(err)
+	assert.Equal(map[string]any{`search`: `{{ .vars.term }}`}, sent(response))
+}
+
+func TestQueryRenderBody(t *testing.T) {
+	var assert = require.New(t)
+
+	var opts = &QueryOptions{
+		BodyQuery: `{"ids": items.id}`,
+	}
+
+	var rendered, err = opts.Render(map[string]any{
+		`items`: []any{
+			map[string]any{`id`: 1},
+			map[string]any{`id`: 2},
+		},
+	})
+
+	assert.NoError(err)
+	assert.Nil(rendered.BodyQuery)
+	assert.Equal(map[string]any{
+		`ids`: []any{1, 2},
+	}, rendered.Body)
+}
@@ -0,0 +1,254 @@
+package orchestra
+
+import (
+	"bytes"
+	"encoding/json"
+	"path"
+	"strings"
+
+	"github.com/ghetzel/go-stockutil/log"
+	"github.com/ghetzel/go-stockutil/typeutil"
+)
+
+// SensitiveKeys are the (case-insensitive, glob) patterns of keys whose values are masked wherever
+// they appear in output: the server's config and debug responses, and debug logs.  These can be
+// replaced using the "sensitive_keys" config option.
+var SensitiveKeys = []string{
+	`authorization`,
+	`proxy-authorization`,
+	`cookie`,
+	`set-cookie`,
+	`*token*`,
+	`*secret*`,
+	`*password*`,
+	`*api_key*`,
+	`*api-key*`,
+	`*apikey*`,
+}
+
+// sensitiveField unwraps a header or param value given as {value: ..., sensitive: true}, returning
+// the value and whether it was marked as sensitive.  Any other value is returned as-is.
+func sensitiveField(field any) (any, bool) {
+	if m, ok := field.(map[string]any); ok && len(m) == 2 {
+		if value, ok := m[`value`]; ok {
+			if sensitive, ok := m[`sensitive`]; ok {
+				return value, typeutil.Bool(sensitive)
+			}
+		}
+	}
+
+	return field, false
+}
+
+// unwrapSensitive returns the value of a header or param.  If it's marked as sensitive, its
+// (lowercase) name is added to sensitive, and a literal value is masked wherever it turns up in
+// output from now on.
+func unwrapSensitive(name string, field any, sensitive map[string]bool) any {
+	var value, ok = sensitiveField(field)
+
+	if ok {
+		if sensitive != nil {
+			sensitive[strings.ToLower(name)] = true
+		}
+
+		// references and templates are masked once they're resolved
+		if s, ok := value.(string); ok && !strings.Contains(s, `{{`) && !rxSecretRef.MatchString(s) {
+			rememberSecret(s)
+		}
+	}
+
+	return value
+}
+
+// IsSensitiveKey returns whether values stored under the given key should be masked.
+func IsSensitiveKey(key string) bool {
+	key = strings.ToLower(key)
+
+	for _, pattern := range SensitiveKeys {
+		if ok, _ := path.Match(strings.ToLower(pattern), key); ok {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Redact returns a JSON-native copy of value with the values of any sensitive keys, fields marked
+// as sensitive, and resolved secrets masked.
+func Redact(value any) (any, error) {
+	if native, err := jsonNative(value); err == nil {
+		return redactValue(native, nil), nil
+	} else {
+		return nil, err
+	}
+}
+
+// redacted returns the response as it's served for debugging: its context and query are redacted,
+// also masking the headers and params the endpoint marked as sensitive, and its result only has
+// resolved secrets masked.
+func (response *QueryResponse) redacted() (map[string]any, error) {
+	if native, err := jsonNative(response); err == nil {
+		var out, _ = native.(map[string]any)
+
+		for _, key := range []string{`context`, `query`} {
+			if v, ok := out[key]; ok {
+				out[key] = redactValue(v, response.sensitive)
+			}
+		}
+
+		if v, ok := out[`result`]; ok {
+			out[`result`] = scrubValue(v)
+		}
+
+		return out, nil
+	} else {
+		return nil, err
+	}
+}
+
+func jsonNative(value any) (any, error) {
+	var native any
+
+	if data, err := json.Marshal(value); err == nil {
+		var decoder = json.NewDecoder(bytes.NewReader(data))
+
+		decoder.UseNumber()
+
+		if err := decoder.Decode(&native); err != nil {
+			return nil, err
+		}
+	} else {
+		return nil, err
+	}
+
+	return native, nil
+}
+
+// redactValue masks sensitive values within a JSON-native value, including those under any of the
+// given (lowercase) names.
+func redactValue(value any, names map[string]bool) any {
+	switch v := value.(type) {
+	case map[string]any:
+		var out = make(map[string]any, len(v))
+
+		if inner, sensitive := sensitiveField(v); sensitive {
+			out[`sensitive`] = v[`sensitive`]
+			out[`value`] = maskValue(inner)
+
+			return out
+		}
+
+		for k, sub := range v {
+			if names[strings.ToLower(k)] || IsSensitiveKey(k) {
+				out[k] = maskValue(sub)
+			} else {
+				out[k] = redactValue(sub, names)
+			}
+		}
+
+		return out
+	case []any:
+		var out = make([]any, len(v))
+
+		for i, sub := range v {
+			out[i] = redactValue(sub, names)
+		}
+
+		return out
+	case string:
+		return scrubSecrets(v)
+	default:
+		return value
+	}
+}
+
+// scrubValue masks any previously-resolved secret values in the strings of a JSON-native value.
+func scrubValue(value any) any {
+	switch v := value.(type) {
+	case map[string]any:
+		var out = make(map[string]any, len(v))
+
+		for k, sub := range v {
+			out[scrubSecrets(k)] = scrubValue(sub)
+		}
+
+		return out
+	case []any:
+		var out = make([]any, len(v))
+
+		for i, sub := range v {
+			out[i] = scrubValue(sub)
+		}
+
+		return out
+	case string:
+		return scrubSecrets(v)
+	default:
+		return value
+	}
+}
+
+// scrubbedResult returns the response's result with any resolved secret values masked.
+func (response *QueryResponse) scrubbedResult() (any, error) {
+	if native, err := jsonNative(response.Result); err == nil {
+		return scrubValue(native), nil
+	} else {
+		return nil, err
+	}
+}
+
+func maskValue(value any) any {
+	if typeutil.IsZero(value) {
+		return value
+	} else {
+		return SecretMask
+	}
+}
+
+// redactNames returns a copy of values with those under any of the given (lowercase) names masked.
+func redactNames(values map[string]any, names map[string]bool) map[string]any {
+	var out = make(map[string]any, len(values))
+
+	for k, v := range values {
+		if names[strings.ToLower(k)] {
+			out[k] = maskValue(v)
+		} else {
+			out[k] = v
+		}
+	}
+
+	return out
+}
+
+// warningf logs a warning, masking any resolved secrets in its arguments.
+func warningf(format string, args ...any) {
+	log.Warningf(format, redactArgs(args)...)
+}
+
+// debugf logs a debug message, masking any sensitive values in its arguments.
+func debugf(format string, args ...any) {
+	log.Debugf(format, redactArgs(args)...)
+}
+
+func redactArgs(args []any) []any {
+	var redacted = make([]any, len(args))
+
+	for i, arg := range args {
+		switch a := arg.(type) {
+		case error:
+			redacted[i] = scrubSecrets(a.Error())
+		case string:
+			redacted[i] = scrubSecrets(a)
+		case map[string]any, QueryContext, []any:
+			if r, err := Redact(a); err == nil {
+				redacted[i] = r
+			} else {
+				redacted[i] = SecretMask
+			}
+		default:
+			redacted[i] = arg
+		}
+	}
+
+	return redacted
+}
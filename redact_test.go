@@ -0,0 +1,186 @@
+package orchestra
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/ghetzel/go-stockutil/httputil"
+	"github.com/ghetzel/testify/require"
+)
+
+func TestRedact(t *testing.T) {
+	var assert = require.New(t)
+
+	rememberSecret(`resolved-value`)
+
+	var out, err = Redact(map[string]any{
+		`headers`: map[string]any{
+			`Authorization`: `Bearer abc`,
+			`X-Auth-Token`:  `def`,
+			`Accept`:        `application/json`,
+			`Cookie`:        ``,
+		},
+		`params`: map[string]any{
+			`page`: 1234567890123,
+			`key`: map[string]any{
+				`value`:     `ghi`,
+				`sensitive`: true,
+			},
+		},
+		`users`: []any{
+			map[string]any{`name`: `alice`, `password`: `jkl`},
+		},
+		`message`: `failed to use resolved-value`,
+	})
+
+	assert.NoError(err)
+	assert.Equal(map[string]any{
+		`headers`: map[string]any{
+			`Authorization`: SecretMask,
+			`X-Auth-Token`:  SecretMask,
+			`Accept`:        `application/json`,
+			`Cookie`:        ``,
+		},
+		`params`: map[string]any{
+			`page`: json.Number(`1234567890123`),
+			`key`: map[string]any{
+				`value`:     SecretMask,
+				`sensitive`: true,
+			},
+		},
+		`users`: []any{
+			map[string]any{`name`: `alice`, `password`: SecretMask},
+		},
+		`message`: `failed to use ` + SecretMask,
+	}, out)
+}
+
+func TestServerRedactsResponses(t *testing.T) {
+	var assert = require.New(t)
+
+	var upstream = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		httputil.RespondJSON(w, map[string]any{
+			`key`:           r.URL.Query().Get(`key`),
+			`echoed`:        r.URL.Query().Get(`echo`),
+			`session_token`: `upstream-token`,
+		})
+	}))
+
+	defer upstream.Close()
+
+	t.Setenv(`ORCHESTRA_TEST_ECHO_SECRET`, `echoed-secret`)
+
+	var cfg = NewConfig()
+
+	cfg.Datasets.Endpoints[`redact-echo`] = &Endpoint{
+		URL: upstream.URL,
+		Headers: map[string]any{
+			`Authorization`: `Bearer plain-token`,
+		},
+		Params: map[string]any{
+			`key`: map[string]any{
+				`value`:     `hunter2-param`,
+				`sensitive`: true,
+			},
+			`echo`: `${env:ORCHESTRA_TEST_ECHO_SECRET}`,
+		},
+	}
+
+	cfg.Datasets.Queries[`redact-test`] = &Schema{
+		Pipeline: &Pipeline{
+			Steps: []*PipelineStep{
+				{
+					ResultTarget: `echo`,
+					WithContext:  true,
+					Query: &QueryOptions{
+						UseEndpoint: `redact-echo`,
+					},
+				},
+			},
+		},
+	}
+
+	assert.NoError(loadDatasets(cfg.Datasets))
+
+	var previous = DefaultConfig
+	DefaultConfig = cfg
+	defer func() { DefaultConfig = previous }()
+
+	var server = NewServer(cfg)
+
+	var get = func(path string) string {
+		var w = httptest.NewRecorder()
+
+		server.ServeHTTP(w, httptest.NewRequest(http.MethodGet, path, nil))
+		assert.Equal(http.StatusOK, w.Code, path)
+
+		return w.Body.String()
+	}
+
+	var body = get(`/orchestra/v1/config/`)
+
+	assert.Contains(body, SecretMask)
+	assert.NotContains(body, `plain-token`)
+	assert.NotContains(body, `hunter2-param`)
+
+	// results are returned as retrieved, with or without debugging details, except that resolved
+	// secrets and values marked as sensitive are masked
+	for _, path := range []string{
+		`/orchestra/v1/queries/redact-test`,
+		`/orchestra/v1/queries/redact-test?_debug=true&password=hunter3-var`,
+	} {
+		body = get(path)
+
+		assert.Contains(body, `"session_token":"upstream-token"`, path)
+		assert.Contains(body, `"key":"`+SecretMask+`"`, path)
+		assert.NotContains(body, `hunter2-param`, path)
+		assert.NotContains(body, `plain-token`, path)
+		assert.Contains(body, `"echoed":"`+SecretMask+`"`, path)
+		assert.NotContains(body, `echoed-secret`, path)
+	}
+
+	// ...but the query and context are redacted
+	assert.Contains(body, `"password":"`+SecretMask+`"`)
+	assert.NotContains(body, `hunter3-var`)
+}
+
+func TestSensitiveMarkersScopedToEndpoint(t *testing.T) {
+	var assert = require.New(t)
+
+	var marked, err = NewQueryOptions().Query(&Endpoint{
+		URL: TestEchoServer.URL,
+		Params: map[string]any{
+			`code`: map[string]any{
+				`value`:     `marked-code`,
+				`sensitive`: true,
+			},
+		},
+	})
+
+	assert.NoError(err)
+
+	var unmarked *QueryResponse
+
+	unmarked, err = NewQueryOptions().Query(&Endpoint{
+		URL: TestEchoServer.URL,
+		Params: map[string]any{
+			`code`: `public-code`,
+		},
+	})
+
+	assert.NoError(err)
+
+	var out map[string]any
+
+	out, err = marked.redacted()
+	assert.NoError(err)
+	assert.Equal(SecretMask, out[`context`].(map[string]any)[`params`].(map[string]any)[`code`])
+	assert.Equal(`code=`+SecretMask, out[`result`].(map[string]any)[`query`])
+
+	out, err = unmarked.redacted()
+	assert.NoError(err)
+	assert.Equal(`public-code`, out[`context`].(map[string]any)[`params`].(map[string]any)[`code`])
+	assert.Equal(`code=public-code`, out[`result`].(map[string]any)[`query`])
+}
@@ -19,6 +19,9 @@ type QueryResponse struct {
 	Errors       []string      `yaml:"errors,omitempty"   json:"errors,omitempty"`
 	Query        *QueryOptions `yaml:"query"              json:"query"`
 	Context      QueryContext  `yaml:"context"            json:"context"`
+
+	// the (lowercase) names of the headers and params marked as sensitive
+	sensitive map[string]bool
 }
 
 func NewQueryResponse(endpoint *Endpoint) *QueryResponse {
@@ -45,7 +48,7 @@ func (response *QueryResponse) Error() error {
 
 func (response *QueryResponse) AddError(errs ...error) error {
 	for _, err := range errs {
-		var msgs = strings.Split(err.Error(), "\n")
+		var msgs = strings.Split(scrubSecrets(err.Error()), "\n")
 		response.Errors = append(response.Errors, msgs...)
 		response.Errors = sliceutil.UniqueStrings(response.Errors)
 	}
@@ -0,0 +1,170 @@
+package orchestra
+
+import (
+	"context"
+	"math"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ghetzel/go-stockutil/sliceutil"
+	"github.com/ghetzel/go-stockutil/typeutil"
+)
+
+const DefaultRetryBackoff = 250 * time.Millisecond
+const DefaultRetryMaxBackoff = 30 * time.Second
+
+var DefaultRetryStatusCodes = []int{
+	http.StatusTooManyRequests,
+	http.StatusInternalServerError,
+	http.StatusBadGateway,
+	http.StatusServiceUnavailable,
+	http.StatusGatewayTimeout,
+}
+
+// DefaultRetryMethods are the idempotent methods that can be retried without repeating side effects.
+var DefaultRetryMethods = []string{
+	http.MethodGet,
+	http.MethodHead,
+	http.MethodOptions,
+	http.MethodPut,
+	http.MethodDelete,
+}
+
+// RetryPolicy controls how failed requests to an endpoint are retried.  Transport errors and
+// responses with one of the retryable status codes are retried using exponential backoff.  Only
+// idempotent methods are retried unless others are listed in methods.
+type RetryPolicy struct {
+	MaxAttempts int      `yaml:"attempts,omitempty"     json:"attempts,omitempty"`
+	Backoff     string   `yaml:"backoff,omitempty"      json:"backoff,omitempty"`
+	MaxBackoff  string   `yaml:"max_backoff,omitempty"  json:"max_backoff,omitempty"`
+	Jitter      float64  `yaml:"jitter,omitempty"       json:"jitter,omitempty"`
+	StatusCodes []int    `yaml:"status_codes,omitempty" json:"status_codes,omitempty"`
+	Methods     []string `yaml:"methods,omitempty"      json:"methods,omitempty"`
+}
+
+type retryableFunc func() (*http.Response, error)
+
+func (policy *RetryPolicy) attempts() int {
+	if policy == nil || policy.MaxAttempts < 1 {
+		return 1
+	} else {
+		return policy.MaxAttempts
+	}
+}
+
+// retriesMethod returns whether requests using the given method may be retried.
+func (policy *RetryPolicy) retriesMethod(method string) bool {
+	var methods = DefaultRetryMethods
+
+	if policy != nil && len(policy.Methods) > 0 {
+		methods = policy.Methods
+	}
+
+	for _, m := range methods {
+		if strings.EqualFold(m, method) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (policy *RetryPolicy) isRetryable(response *http.Response, err error) bool {
+	if err == nil {
+		return false
+	} else if response == nil {
+		return true
+	} else if len(policy.StatusCodes) > 0 {
+		return sliceutil.Contains(policy.StatusCodes, response.StatusCode)
+	} else {
+		return sliceutil.Contains(DefaultRetryStatusCodes, response.StatusCode)
+	}
+}
+
+// delay returns how long to wait before making the given attempt (starting from 1 for the first retry).
+func (policy *RetryPolicy) delay(attempt int, response *http.Response) time.Duration {
+	var base = typeutil.Duration(policy.Backoff)
+	var ceiling = typeutil.Duration(policy.MaxBackoff)
+	var wait time.Duration
+
+	if base <= 0 {
+		base = DefaultRetryBackoff
+	}
+
+	if ceiling <= 0 {
+		ceiling = DefaultRetryMaxBackoff
+	}
+
+	if after, ok := retryAfter(response); ok {
+		wait = after
+	} else {
+		wait = time.Duration(math.Min(
+			float64(base)*math.Pow(2, float64(attempt-1)),
+			float64(ceiling),
+		))
+
+		if policy.Jitter > 0 {
+			wait -= time.Duration(rand.Float64() * math.Min(policy.Jitter, 1) * float64(wait))
+		}
+	}
+
+	if wait > ceiling {
+		wait = ceiling
+	} else if wait < 0 {
+		wait = 0
+	}
+
+	return wait
+}
+
+// do calls fn until it succeeds, returns a non-retryable error, or the attempts are exhausted.  The
+// number of attempts made is returned alongside the final response.  Requests using a method the
+// policy doesn't retry are only attempted once.
+func (policy *RetryPolicy) do(ctx context.Context, method string, fn retryableFunc) (*http.Response, int, error) {
+	var maxAttempts = policy.attempts()
+
+	if !policy.retriesMethod(method) {
+		maxAttempts = 1
+	}
+
+	for attempt := 1; ; attempt++ {
+		var response, err = fn()
+
+		if attempt >= maxAttempts || ctx.Err() != nil || !policy.isRetryable(response, err) {
+			return response, attempt, err
+		}
+
+		var wait = policy.delay(attempt, response)
+
+		if response != nil {
+			response.Body.Close()
+		}
+
+		debugf("retry: attempt %d/%d failed, waiting %v: %v", attempt, maxAttempts, wait, err)
+
+		select {
+		case <-ctx.Done():
+			return nil, attempt, ctx.Err()
+		case <-time.After(wait):
+		}
+	}
+}
+
+// retryAfter parses the Retry-After header of the given response, which is either a number of
+// seconds or an HTTP date.
+func retryAfter(response *http.Response) (time.Duration, bool) {
+	if response == nil {
+		return 0, false
+	} else if value := strings.TrimSpace(response.Header.Get(`Retry-After`)); value == `` {
+		return 0, false
+	} else if secs, err := strconv.Atoi(value); err == nil {
+		return time.Duration(secs) * time.Second, true
+	} else if at, err := http.ParseTime(value); err == nil {
+		return time.Until(at), true
+	} else {
+		return 0, false
+	}
+}
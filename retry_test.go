@@ -0,0 +1,137 @@
+package orchestra
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/ghetzel/go-stockutil/httputil"
+	"github.com/ghetzel/testify/require"
+)
+
+func testFlakyServer(failures int32, status int) (*httptest.Server, *int32) {
+	var calls int32
+
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&calls, 1) <= failures {
+			w.Header().Set(`Retry-After`, `0`)
+			httputil.RespondJSON(w, fmt.Errorf("try again"), status)
+		} else {
+			httputil.RespondJSON(w, map[string]any{`ok`: true})
+		}
+	})), &calls
+}
+
+func TestRetrySucceedsAfterFailures(t *testing.T) {
+	var assert = require.New(t)
+	var server, calls = testFlakyServer(2, http.StatusServiceUnavailable)
+	defer server.Close()
+
+	var response, err = NewQueryOptions().Query(&Endpoint{
+		URL: server.URL,
+		Retry: &RetryPolicy{
+			MaxAttempts: 3,
+			Backoff:     `1ms`,
+		},
+	})
+
+	assert.NoError(err)
+	assert.Equal(map[string]any{`ok`: true}, response.Result)
+	assert.Equal(3, response.Context[`attempts`])
+	assert.EqualValues(3, atomic.LoadInt32(calls))
+}
+
+func TestRetryExhausted(t *testing.T) {
+	var assert = require.New(t)
+	var server, calls = testFlakyServer(5, http.StatusBadGateway)
+	defer server.Close()
+
+	var response, err = NewQueryOptions().Query(&Endpoint{
+		URL: server.URL,
+		Retry: &RetryPolicy{
+			MaxAttempts: 2,
+			Backoff:     `1ms`,
+		},
+	})
+
+	assert.Error(err)
+	assert.Equal(2, response.Context[`attempts`])
+	assert.EqualValues(2, atomic.LoadInt32(calls))
+}
+
+func TestRetryNonRetryableStatus(t *testing.T) {
+	var assert = require.New(t)
+	var server, calls = testFlakyServer(5, http.StatusBadRequest)
+	defer server.Close()
+
+	var _, err = NewQueryOptions().Query(&Endpoint{
+		URL: server.URL,
+		Retry: &RetryPolicy{
+			MaxAttempts: 4,
+			Backoff:     `1ms`,
+		},
+	})
+
+	assert.Error(err)
+	assert.EqualValues(1, atomic.LoadInt32(calls))
+}
+
+func TestRetryDelay(t *testing.T) {
+	var assert = require.New(t)
+	var policy = &RetryPolicy{
+		Backoff:    `100ms`,
+		MaxBackoff: `1s`,
+	}
+
+	assert.Equal(100*time.Millisecond, policy.delay(1, nil))
+	assert.Equal(400*time.Millisecond, policy.delay(3, nil))
+	assert.Equal(time.Second, policy.delay(10, nil))
+
+	assert.Equal(time.Second, policy.delay(1, &http.Response{
+		Header: http.Header{
+			`Retry-After`: []string{`5`},
+		},
+	}))
+
+	policy.Jitter = 0.5
+
+	for i := 0; i < 10; i++ {
+		var d = policy.delay(2, nil)
+		assert.True(d > 100*time.Millisecond && d <= 200*time.Millisecond)
+	}
+}
+
+func TestRetryIdempotentMethods(t *testing.T) {
+	var assert = require.New(t)
+
+	for _, tc := range []struct {
+		method  string
+		methods []string
+		calls   int32
+	}{
+		{`post`, nil, 1},
+		{`patch`, nil, 1},
+		{`put`, nil, 3},
+		{`delete`, nil, 3},
+		{`post`, []string{`post`}, 3},
+		{`get`, []string{`POST`}, 1},
+	} {
+		var server, calls = testFlakyServer(2, http.StatusServiceUnavailable)
+
+		NewQueryOptions().Query(&Endpoint{
+			URL:    server.URL,
+			Method: tc.method,
+			Retry: &RetryPolicy{
+				MaxAttempts: 3,
+				Backoff:     `1ms`,
+				Methods:     tc.methods,
+			},
+		})
+
+		server.Close()
+		assert.EqualValues(tc.calls, atomic.LoadInt32(calls), tc.method)
+	}
+}
@@ -1,5 +1,65 @@
 package orchestra
 
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// MaxQueryDepth limits how deeply queries may call other queries.
+var MaxQueryDepth = 8
+
+type schemaCallKey struct{}
+
+// schemaCall tracks the dataset being queried and the chain of named queries that led here.
+type schemaCall struct {
+	dataset *DatasetConfig
+	chain   []string
+}
+
+func currentSchemaCall(ctx context.Context) *schemaCall {
+	if call, ok := ctx.Value(schemaCallKey{}).(*schemaCall); ok {
+		return call
+	} else {
+		return new(schemaCall)
+	}
+}
+
+// enterSchema returns a context recording that the named query in dataset is being run, or an
+// error if doing so would recurse or exceed MaxQueryDepth.
+func enterSchema(ctx context.Context, dataset *DatasetConfig, name string) (context.Context, error) {
+	var call = currentSchemaCall(ctx)
+	var chain = append(slices.Clone(call.chain), name)
+
+	if slices.Contains(call.chain, name) {
+		return nil, fmt.Errorf("query cycle: %s", strings.Join(chain, ` -> `))
+	} else if len(call.chain) >= MaxQueryDepth {
+		return nil, fmt.Errorf("maximum query depth (%d) exceeded: %s", MaxQueryDepth, strings.Join(chain, ` -> `))
+	}
+
+	return context.WithValue(ctx, schemaCallKey{}, &schemaCall{
+		dataset: dataset,
+		chain:   chain,
+	}), nil
+}
+
+// callSchema runs the named query from the dataset currently being queried, falling back to the
+// default configuration.
+func callSchema(ctx context.Context, name string, query *QueryOptions) (*QueryResponse, error) {
+	var dataset = currentSchemaCall(ctx).dataset
+
+	if dataset == nil && DefaultConfig != nil {
+		dataset = DefaultConfig.Datasets
+	}
+
+	if dataset == nil {
+		return nil, fmt.Errorf("undefined schema %q", name)
+	}
+
+	return dataset.QuerySchemaWithContext(ctx, name, query)
+}
+
 type Schema struct {
 	Name     string    `yaml:"name,omitempty"     json:"name,omitempty"`
 	Summary  string    `yaml:"summary,omitempty"  json:"summary,omitempty"`
@@ -7,10 +67,14 @@ type Schema struct {
 }
 
 func (schema *Schema) Query(query *QueryOptions) (*QueryResponse, error) {
+	return schema.QueryWithContext(context.Background(), query)
+}
+
+func (schema *Schema) QueryWithContext(ctx context.Context, query *QueryOptions) (*QueryResponse, error) {
 	var queryResponse = NewQueryResponse(nil)
 
 	if pipeline := schema.Pipeline; pipeline != nil {
-		if res, err := pipeline.Query(query); err == nil {
+		if res, err := pipeline.QueryWithContext(ctx, query); err == nil {
 			queryResponse = res
 		} else {
 			return queryResponse.Failed(err)
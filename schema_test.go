@@ -1,11 +1,13 @@
 package orchestra
 
 import (
+	"context"
 	"fmt"
 	"net/http"
 	"net/http/httptest"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/ghetzel/go-stockutil/httputil"
 	"github.com/ghetzel/go-stockutil/sliceutil"
@@ -202,3 +204,147 @@ func TestSchemaBasicQuery(t *testing.T) {
 // 	}
 
 // }
+
+func TestSchemaStepTimeout(t *testing.T) {
+	var assert = require.New(t)
+	var server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-r.Context().Done():
+		case <-time.After(5 * time.Second):
+		}
+
+		httputil.RespondJSON(w, true)
+	}))
+	defer server.Close()
+
+	RegisterEndpoint(`slow`, &Endpoint{
+		URL: server.URL,
+	})
+
+	var schema = &Schema{
+		Name: `test-timeout`,
+		Pipeline: &Pipeline{
+			Steps: []*PipelineStep{
+				{
+					ResultTarget: `slow`,
+					Timeout:      `50ms`,
+					Optional:     true,
+					Query: &QueryOptions{
+						UseEndpoint: `slow`,
+					},
+				}, {
+					ResultTarget: `kubes`,
+					Query: &QueryOptions{
+						UseEndpoint: `k8s`,
+					},
+				},
+			},
+		},
+	}
+
+	var response, err = schema.Query(nil)
+	assert.NoError(err)
+
+	var results, ok = response.Result.(map[string]any)
+	assert.True(ok)
+	assert.Nil(results[`slow`])
+	assert.Len(results[`kubes`], 4)
+
+	schema.Pipeline.Steps[0].Optional = false
+	schema.Pipeline.Steps[0].Timeout = ``
+	schema.Pipeline.Timeout = `50ms`
+
+	var started = time.Now()
+	_, err = schema.Query(nil)
+	assert.Error(err)
+	assert.True(time.Since(started) < 2*time.Second)
+}
+
+func TestSchemaComposition(t *testing.T) {
+	var assert = require.New(t)
+
+	var dataset = NewConfig().Datasets
+	dataset.Queries[`double`] = &Schema{
+		Pipeline: &Pipeline{
+			Steps: []*PipelineStep{
+				{
+					ResultTarget: `value`,
+					Transforms:   []any{`$number($n) * 2`},
+				},
+			},
+		},
+	}
+
+	dataset.Queries[`outer`] = &Schema{
+		Pipeline: &Pipeline{
+			Steps: []*PipelineStep{
+				{
+					ResultTarget: `single`,
+					Query: &QueryOptions{
+						Schema: `double`,
+						Context: Context{
+							Variables: map[string]any{`n`: 21},
+						},
+					},
+					Transforms: []any{`value`},
+				}, {
+					ResultTarget: `many`,
+					Query: &QueryOptions{
+						Schema:         `double`,
+						ForEach:        `[1, 2, 3]`,
+						VariablesQuery: `{"n": item}`,
+					},
+					Transforms: []any{`value`},
+				},
+			},
+		},
+	}
+
+	var response, err = dataset.QuerySchema(`outer`, nil)
+	assert.NoError(err)
+
+	var results = response.Result.(map[string]any)
+	assert.EqualValues(42, results[`single`])
+	assert.EqualValues([]any{float64(2), float64(4), float64(6)}, results[`many`])
+}
+
+func TestSchemaCompositionLimits(t *testing.T) {
+	var assert = require.New(t)
+
+	var dataset = NewConfig().Datasets
+	dataset.Queries[`self`] = &Schema{
+		Pipeline: &Pipeline{
+			Steps: []*PipelineStep{
+				{
+					Query: &QueryOptions{
+						Schema: `self`,
+					},
+				},
+			},
+		},
+	}
+
+	var _, err = dataset.QuerySchema(`self`, nil)
+	assert.Error(err)
+	assert.Contains(err.Error(), `query cycle: self -> self`)
+
+	err = dataset.validateSchemaCalls()
+	assert.Error(err)
+	assert.Contains(err.Error(), `query cycle`)
+
+	dataset.Queries[`self`].Pipeline.Steps[0].Query.Schema = `missing`
+	err = dataset.validateSchemaCalls()
+	assert.Error(err)
+	assert.Contains(err.Error(), `undefined schema "missing"`)
+
+	var ctx = context.Background()
+
+	for i := 0; i < MaxQueryDepth; i++ {
+		ctx, err = enterSchema(ctx, dataset, fmt.Sprintf("q%d", i))
+		assert.NoError(err)
+	}
+
+	_, err = enterSchema(ctx, dataset, `one-too-many`)
+	assert.Error(err)
+	assert.Contains(err.Error(), `maximum query depth`)
+}
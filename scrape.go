@@ -0,0 +1,148 @@
+package orchestra
+
+import (
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/PuerkitoBio/goquery"
+	"github.com/ghetzel/go-stockutil/maputil"
+	"github.com/ghetzel/go-stockutil/typeutil"
+)
+
+// scrapeField describes how to extract a single value from an HTML document.  In configuration, a
+// field is either a selector string (optionally followed by " @attr" to read an attribute instead
+// of the text), or an object with the keys:
+//
+//	selector: CSS selector, relative to the enclosing row (if any); empty to use the row itself
+//	attr:     read this attribute instead of the element's text
+//	html:     return the element's inner HTML instead of its text
+//	list:     return a value for every match instead of just the first
+//	fields:   extract an object from each match (implies list unless list is false)
+type scrapeField struct {
+	selector string
+	attr     string
+	html     bool
+	list     bool
+	fields   map[string]*scrapeField
+}
+
+func parseScrapeFields(spec map[string]any) (map[string]*scrapeField, error) {
+	var fields = make(map[string]*scrapeField)
+
+	for name, fieldSpec := range spec {
+		if field, err := parseScrapeField(fieldSpec); err == nil {
+			fields[name] = field
+		} else {
+			return nil, fmt.Errorf("scrape %s: %v", name, err)
+		}
+	}
+
+	return fields, nil
+}
+
+func parseScrapeField(spec any) (*scrapeField, error) {
+	if selector, ok := spec.(string); ok {
+		var field = &scrapeField{
+			selector: strings.TrimSpace(selector),
+		}
+
+		if i := strings.LastIndex(field.selector, `@`); i >= 0 && !strings.ContainsAny(field.selector[i:], ` ]"'`) {
+			field.attr = field.selector[i+1:]
+			field.selector = strings.TrimSpace(field.selector[:i])
+		}
+
+		return field, nil
+	} else if typeutil.IsMap(spec) {
+		var m = maputil.M(spec)
+		var field = &scrapeField{
+			selector: m.String(`selector`),
+			attr:     m.String(`attr`),
+			html:     m.Bool(`html`),
+			list:     m.Bool(`list`),
+		}
+
+		if nested := m.Get(`fields`); !nested.IsNil() {
+			if !typeutil.IsMap(nested.Value) {
+				return nil, fmt.Errorf("fields must be an object")
+			} else if fields, err := parseScrapeFields(maputil.M(nested.Value).MapNative()); err == nil {
+				field.fields = fields
+			} else {
+				return nil, err
+			}
+
+			if m.Get(`list`).IsNil() {
+				field.list = true
+			}
+		}
+
+		return field, nil
+	} else {
+		return nil, fmt.Errorf("expected a selector string or object, got %T", spec)
+	}
+}
+
+// extract returns this field's value from the given selection.
+func (field *scrapeField) extract(sel *goquery.Selection) any {
+	var matches = sel
+
+	if field.selector != `` {
+		matches = sel.Find(field.selector)
+	}
+
+	if field.list {
+		var values = make([]any, 0, matches.Length())
+
+		matches.Each(func(_ int, match *goquery.Selection) {
+			values = append(values, field.value(match))
+		})
+
+		return values
+	} else if matches.Length() > 0 {
+		return field.value(matches.First())
+	} else {
+		return nil
+	}
+}
+
+func (field *scrapeField) value(match *goquery.Selection) any {
+	if len(field.fields) > 0 {
+		var row = make(map[string]any)
+
+		for name, subfield := range field.fields {
+			row[name] = subfield.extract(match)
+		}
+
+		return row
+	} else if field.attr != `` {
+		if value, ok := match.Attr(field.attr); ok {
+			return value
+		} else {
+			return nil
+		}
+	} else if field.html {
+		var html, _ = match.Html()
+		return strings.TrimSpace(html)
+	} else {
+		return strings.TrimSpace(match.Text())
+	}
+}
+
+// scrapeHTML parses r as an HTML document and extracts an object with one key per field in spec.
+func scrapeHTML(r io.Reader, spec map[string]any) (any, error) {
+	if fields, err := parseScrapeFields(spec); err == nil {
+		if doc, err := goquery.NewDocumentFromReader(r); err == nil {
+			var out = make(map[string]any)
+
+			for name, field := range fields {
+				out[name] = field.extract(doc.Selection)
+			}
+
+			return out, nil
+		} else {
+			return nil, err
+		}
+	} else {
+		return nil, err
+	}
+}
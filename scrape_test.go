@@ -0,0 +1,83 @@
+package orchestra
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/ghetzel/testify/require"
+)
+
+const testScrapePage = `<html>
+<head><title> Staff Directory </title></head>
+<body>
+	<img class="logo" src="/logo.png">
+	<ul class="tags"><li>one</li><li>two</li></ul>
+	<table id="staff">
+		<tr><td class="name">Alice</td><td><a href="mailto:alice@example.com">email</a></td></tr>
+		<tr><td class="name">Bob</td><td><a href="mailto:bob@example.com">email</a></td></tr>
+	</table>
+	<div id="motd"><b>hello</b></div>
+</body>
+</html>`
+
+func TestScrapeEndpoint(t *testing.T) {
+	var assert = require.New(t)
+
+	var server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set(`Content-Type`, `text/html`)
+		w.Write([]byte(testScrapePage))
+	}))
+	defer server.Close()
+
+	var response, err = NewQueryOptions().Query(&Endpoint{
+		URL: server.URL,
+		Scrape: map[string]any{
+			`title`:   `title`,
+			`logo`:    `img.logo @src`,
+			`missing`: `.nope`,
+			`tags`: map[string]any{
+				`selector`: `ul.tags li`,
+				`list`:     true,
+			},
+			`motd`: map[string]any{
+				`selector`: `#motd`,
+				`html`:     true,
+			},
+			`staff`: map[string]any{
+				`selector`: `#staff tr`,
+				`fields`: map[string]any{
+					`name`:  `td.name`,
+					`email`: `a[href^="mailto:"] @href`,
+				},
+			},
+		},
+		ResultFilters: []any{
+			`$merge([$, {"count": $count(staff)}])`,
+		},
+	})
+
+	assert.NoError(err)
+	assert.Equal(map[string]any{
+		`title`: `Staff Directory`,
+		`logo`:  `/logo.png`,
+		`tags`:  []any{`one`, `two`},
+		`motd`:  `<b>hello</b>`,
+		`staff`: []any{
+			map[string]any{`name`: `Alice`, `email`: `mailto:alice@example.com`},
+			map[string]any{`name`: `Bob`, `email`: `mailto:bob@example.com`},
+		},
+		`count`: 2,
+	}, response.Result)
+}
+
+func TestScrapeInvalidField(t *testing.T) {
+	var assert = require.New(t)
+
+	var _, err = parseScrapeFields(map[string]any{
+		`bad`: 42,
+	})
+
+	assert.Error(err)
+	assert.Contains(err.Error(), `scrape bad`)
+}
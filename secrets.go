@@ -0,0 +1,237 @@
+package orchestra
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/ghetzel/go-stockutil/fileutil"
+)
+
+// SecretMask replaces resolved secrets, and any other sensitive values, in output.
+const SecretMask = `********`
+
+// MinSecretLength is the length a secret value must have for it to be masked wherever it turns up in
+// output; shorter values (e.g.: a page size read from the environment) would mask unrelated text.
+const MinSecretLength = 6
+
+// maxTrackedSecrets bounds the number of secret values remembered for masking, so that secrets which
+// rotate don't accumulate for the life of the process.  The oldest are forgotten first.
+const maxTrackedSecrets = 1024
+
+var rxSecretRef = regexp.MustCompile(`\$\{([A-Za-z][\w-]*):([^}]*)\}`)
+
+// SecretProvider looks up the value of a secret reference.  References are written in
+// configuration as "${provider:ref}", e.g.: "${env:GITHUB_TOKEN}" or "${file:/run/secrets/token}".
+type SecretProvider interface {
+	Secret(ctx context.Context, ref string) (string, error)
+}
+
+// SecretProviderFunc adapts a function to the SecretProvider interface.
+type SecretProviderFunc func(ctx context.Context, ref string) (string, error)
+
+func (fn SecretProviderFunc) Secret(ctx context.Context, ref string) (string, error) {
+	return fn(ctx, ref)
+}
+
+var registeredSecretProviders = map[string]SecretProvider{
+	`env`:  SecretProviderFunc(envSecret),
+	`file`: SecretProviderFunc(fileSecret),
+}
+
+var secretProvidersLock sync.RWMutex
+
+// resolvedSecrets holds the most recently resolved secret values, along with any values marked as
+// sensitive, so they can be masked wherever they turn up in output.
+var resolvedSecrets = struct {
+	sync.Mutex
+	values map[string]bool
+	order  []string
+}{
+	values: make(map[string]bool),
+}
+
+// RegisterSecretProvider makes a secret provider available to references of the form
+// "${name:ref}".  Registering a name again replaces the existing provider (including built-in ones).
+func RegisterSecretProvider(name string, provider SecretProvider) {
+	secretProvidersLock.Lock()
+	defer secretProvidersLock.Unlock()
+
+	registeredSecretProviders[strings.ToLower(name)] = provider
+}
+
+// GetSecretProvider returns the secret provider registered under the given name.
+func GetSecretProvider(name string) (SecretProvider, bool) {
+	secretProvidersLock.RLock()
+	defer secretProvidersLock.RUnlock()
+
+	var provider, ok = registeredSecretProviders[strings.ToLower(name)]
+	return provider, ok && provider != nil
+}
+
+// envSecret reads a secret from an environment variable, which must be set.
+func envSecret(ctx context.Context, ref string) (string, error) {
+	if value, ok := os.LookupEnv(ref); ok {
+		return value, nil
+	} else {
+		return ``, fmt.Errorf("environment variable %s is not set", ref)
+	}
+}
+
+// fileSecret reads a secret from a file, without any trailing newline.
+func fileSecret(ctx context.Context, ref string) (string, error) {
+	if path, err := fileutil.ExpandUser(ref); err == nil {
+		if data, err := os.ReadFile(path); err == nil {
+			return strings.TrimRight(string(data), "\r\n"), nil
+		} else {
+			return ``, err
+		}
+	} else {
+		return ``, err
+	}
+}
+
+// ResolveSecrets replaces any secret references within the strings of value (recursing into maps and
+// slices) with the secrets they refer to.  Secrets are resolved each time they are used rather than
+// when configuration is loaded, so that rotated secrets are picked up and resolved values are never
+// held in (or served from) the configuration.  Only values taken from configuration should be
+// resolved, never anything rendered from variables or results, and the result should never be
+// recorded in the request context; see Request.Resolve.
+func ResolveSecrets(ctx context.Context, value any) (any, error) {
+	return resolveSecrets(ctx, value, false)
+}
+
+// resolveSecretTemplates resolves the secret references within a template from configuration, before
+// it is rendered.  Resolved values are escaped so that they are rendered verbatim.
+func resolveSecretTemplates(ctx context.Context, value any) (any, error) {
+	return resolveSecrets(ctx, value, true)
+}
+
+func resolveSecrets(ctx context.Context, value any, template bool) (any, error) {
+	switch v := value.(type) {
+	case string:
+		return resolveSecretRefs(ctx, v, template)
+	case map[string]any:
+		var out = make(map[string]any, len(v))
+
+		for k, sub := range v {
+			if resolved, err := resolveSecrets(ctx, sub, template); err == nil {
+				out[k] = resolved
+			} else {
+				return nil, err
+			}
+		}
+
+		return out, nil
+	case []any:
+		var out = make([]any, len(v))
+
+		for i, sub := range v {
+			if resolved, err := resolveSecrets(ctx, sub, template); err == nil {
+				out[i] = resolved
+			} else {
+				return nil, err
+			}
+		}
+
+		return out, nil
+	default:
+		return value, nil
+	}
+}
+
+func resolveSecretMap(ctx context.Context, in map[string]any) (map[string]any, error) {
+	if in == nil {
+		return nil, nil
+	} else if out, err := resolveSecrets(ctx, in, false); err == nil {
+		return out.(map[string]any), nil
+	} else {
+		return nil, err
+	}
+}
+
+func resolveSecretString(ctx context.Context, in string) (string, error) {
+	return resolveSecretRefs(ctx, in, false)
+}
+
+func resolveSecretRefs(ctx context.Context, in string, template bool) (string, error) {
+	if !strings.Contains(in, `${`) {
+		return in, nil
+	}
+
+	var firstErr error
+
+	var out = rxSecretRef.ReplaceAllStringFunc(in, func(ref string) string {
+		var match = rxSecretRef.FindStringSubmatch(ref)
+
+		if firstErr != nil {
+			return ``
+		} else if provider, ok := GetSecretProvider(match[1]); ok {
+			if value, err := provider.Secret(ctx, match[2]); err == nil {
+				rememberSecret(value)
+
+				if template {
+					value = strings.ReplaceAll(value, `{{`, `{{"{{"}}`)
+				}
+
+				return value
+			} else {
+				firstErr = fmt.Errorf("secret %s: %v", ref, err)
+			}
+		} else {
+			firstErr = fmt.Errorf("secret %s: undefined secret provider %q", ref, match[1])
+		}
+
+		return ``
+	})
+
+	if firstErr != nil {
+		return ``, firstErr
+	}
+
+	return out, nil
+}
+
+// rememberSecret records a secret value so that it is masked in output, unless it is too short.
+func rememberSecret(value string) {
+	if len(value) < MinSecretLength {
+		return
+	}
+
+	resolvedSecrets.Lock()
+	defer resolvedSecrets.Unlock()
+
+	if resolvedSecrets.values[value] {
+		return
+	}
+
+	resolvedSecrets.values[value] = true
+	resolvedSecrets.order = append(resolvedSecrets.order, value)
+
+	if len(resolvedSecrets.order) > maxTrackedSecrets {
+		delete(resolvedSecrets.values, resolvedSecrets.order[0])
+		resolvedSecrets.order = resolvedSecrets.order[1:]
+	}
+}
+
+// scrubSecrets masks any previously-resolved secret values that appear in the given message.
+func scrubSecrets(msg string) string {
+	resolvedSecrets.Lock()
+	var secrets = append([]string(nil), resolvedSecrets.order...)
+	resolvedSecrets.Unlock()
+
+	// longest first, so secrets containing other secrets are masked whole
+	sort.Slice(secrets, func(i, j int) bool {
+		return len(secrets[i]) > len(secrets[j])
+	})
+
+	for _, secret := range secrets {
+		msg = strings.ReplaceAll(msg, secret, SecretMask)
+	}
+
+	return msg
+}
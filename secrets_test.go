@@ -0,0 +1,192 @@
+package orchestra
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/ghetzel/go-stockutil/httputil"
+	"github.com/ghetzel/go-stockutil/typeutil"
+	"github.com/ghetzel/testify/require"
+)
+
+func TestResolveSecrets(t *testing.T) {
+	var assert = require.New(t)
+	var ctx = context.Background()
+	var secretFile = filepath.Join(t.TempDir(), `token`)
+
+	assert.NoError(os.WriteFile(secretFile, []byte("from-file\n"), 0600))
+	t.Setenv(`ORCHESTRA_TEST_SECRET`, `from-env`)
+
+	RegisterSecretProvider(`Vault`, SecretProviderFunc(func(ctx context.Context, ref string) (string, error) {
+		if ref == `kv/api#key` {
+			return `from-vault`, nil
+		} else {
+			return ``, fmt.Errorf("no such secret")
+		}
+	}))
+
+	defer func() {
+		secretProvidersLock.Lock()
+		delete(registeredSecretProviders, `vault`)
+		secretProvidersLock.Unlock()
+	}()
+
+	var out, err = ResolveSecrets(ctx, map[string]any{
+		`env`:   `Bearer ${env:ORCHESTRA_TEST_SECRET}`,
+		`file`:  `${file:` + secretFile + `}`,
+		`vault`: []any{`${vault:kv/api#key}`, 42},
+		`plain`: `${not a ref}`,
+	})
+
+	assert.NoError(err)
+	assert.Equal(map[string]any{
+		`env`:   `Bearer from-env`,
+		`file`:  `from-file`,
+		`vault`: []any{`from-vault`, 42},
+		`plain`: `${not a ref}`,
+	}, out)
+
+	_, err = ResolveSecrets(ctx, `${env:ORCHESTRA_TEST_UNSET}`)
+	assert.Error(err)
+	assert.Contains(err.Error(), `environment variable ORCHESTRA_TEST_UNSET is not set`)
+
+	_, err = ResolveSecrets(ctx, `${vault:kv/other}`)
+	assert.Error(err)
+	assert.Contains(err.Error(), `no such secret`)
+
+	_, err = ResolveSecrets(ctx, `${nope:x}`)
+	assert.Error(err)
+	assert.Contains(err.Error(), `undefined secret provider "nope"`)
+
+	assert.Equal(`token ******** failed`, scrubSecrets(`token from-vault failed`))
+
+	// short values would mask unrelated text, so aren't masked at all
+	t.Setenv(`ORCHESTRA_TEST_PAGE`, `10`)
+
+	out, err = ResolveSecrets(ctx, `${env:ORCHESTRA_TEST_PAGE}`)
+	assert.NoError(err)
+	assert.Equal(`10`, out)
+	assert.Equal(`found 100 items in 2010`, scrubSecrets(`found 100 items in 2010`))
+
+	// only the most recently resolved secrets are remembered
+	for i := 0; i < maxTrackedSecrets; i++ {
+		rememberSecret(fmt.Sprintf("rotated-secret-%d", i))
+	}
+
+	assert.Equal(`token from-vault failed`, scrubSecrets(`token from-vault failed`))
+	assert.Equal(`token ******** failed`, scrubSecrets(`token rotated-secret-1 failed`))
+}
+
+func TestSecretsNeverExposed(t *testing.T) {
+	var assert = require.New(t)
+
+	t.Setenv(`ORCHESTRA_TEST_API_KEY`, `sekrit-api-key`)
+
+	var server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get(`key`) == `sekrit-api-key` {
+			httputil.RespondJSON(w, map[string]any{
+				`authorization`: r.Header.Get(`Authorization`),
+			})
+		} else {
+			http.Error(w, `bad key`, http.StatusForbidden)
+		}
+	}))
+
+	defer server.Close()
+
+	var endpoint = &Endpoint{
+		URL: server.URL,
+		Headers: map[string]any{
+			`Authorization`: `Bearer ${env:ORCHESTRA_TEST_API_KEY}`,
+		},
+		Params: map[string]any{
+			`key`: `${env:ORCHESTRA_TEST_API_KEY}`,
+		},
+	}
+
+	var response, err = NewQueryOptions().Query(endpoint)
+
+	assert.NoError(err)
+	assert.Equal(map[string]any{
+		`authorization`: `Bearer sekrit-api-key`,
+	}, response.Result)
+
+	// neither the configuration nor the response's debug details contain the resolved value
+	response.Result = nil
+
+	assert.NotContains(typeutil.JSON(endpoint), `sekrit-api-key`)
+	assert.NotContains(typeutil.JSON(response), `sekrit-api-key`)
+	assert.Contains(typeutil.JSON(response.Context), `${env:ORCHESTRA_TEST_API_KEY}`)
+
+	// ...nor do errors that happen to mention it
+	server.Close()
+
+	response, err = NewQueryOptions().Query(endpoint)
+	assert.Error(err)
+	assert.NotContains(err.Error(), `sekrit-api-key`)
+	assert.Contains(strings.Join(response.Errors, "\n"), `key=`+SecretMask)
+
+	// secrets are resolved as each request is made
+	server = httptest.NewServer(server.Config.Handler)
+	defer server.Close()
+
+	endpoint.URL = server.URL
+	t.Setenv(`ORCHESTRA_TEST_API_KEY`, `rotated`)
+
+	_, err = NewQueryOptions().Query(endpoint)
+	assert.Error(err)
+	assert.Contains(err.Error(), `403`)
+}
+
+func TestSecretsOnlyResolvedFromConfig(t *testing.T) {
+	var assert = require.New(t)
+
+	t.Setenv(`ORCHESTRA_TEST_API_KEY`, `sekrit-{{ .vars.q }}`)
+
+	var endpoint = &Endpoint{
+		Method: `post`,
+		URL:    TestEchoServer.URL + `/?q={{ .vars.q }}&key=${env:ORCHESTRA_TEST_API_KEY}`,
+		RequestBody: map[string]any{
+			`q`:   `{{ .vars.q }}`,
+			`key`: `${env:ORCHESTRA_TEST_API_KEY}`,
+		},
+		Params: map[string]any{
+			`p`: `${env:ORCHESTRA_TEST_API_KEY}`,
+		},
+	}
+
+	// references within variables and query params are sent as-is, and resolved values (even ones
+	// that look like templates) aren't rendered
+	var opts = NewQueryOptions()
+	opts.Variables[`q`] = `${env:ORCHESTRA_TEST_API_KEY}`
+	opts.Params[`x`] = `${env:ORCHESTRA_TEST_API_KEY}`
+
+	var response, err = opts.Query(endpoint)
+	assert.NoError(err)
+
+	var sent = response.Result.(map[string]any)
+	var query, _ = url.ParseQuery(typeutil.String(sent[`query`]))
+
+	assert.Equal(`${env:ORCHESTRA_TEST_API_KEY}`, query.Get(`q`))
+	assert.Equal(`sekrit-{{ .vars.q }}`, query.Get(`key`))
+	assert.Equal(`sekrit-{{ .vars.q }}`, query.Get(`p`))
+	assert.Equal(`${env:ORCHESTRA_TEST_API_KEY}`, query.Get(`x`))
+	assert.JSONEq(`{"q": "${env:ORCHESTRA_TEST_API_KEY}", "key": "sekrit-{{ .vars.q }}"}`, typeutil.String(sent[`body`]))
+
+	// the same goes for a param from the endpoint's configuration that the query replaces
+	opts = NewQueryOptions()
+	opts.Params[`p`] = `${env:ORCHESTRA_TEST_API_KEY}`
+
+	response, err = opts.Query(endpoint)
+	assert.NoError(err)
+
+	query, _ = url.ParseQuery(typeutil.String(response.Result.(map[string]any)[`query`]))
+	assert.Equal(`${env:ORCHESTRA_TEST_API_KEY}`, query.Get(`p`))
+}
@@ -1,6 +1,7 @@
 package orchestra
 
 import (
+	"errors"
 	"fmt"
 	_ "image/gif"
 	_ "image/jpeg"
@@ -62,7 +63,11 @@ func (server *Server) init() {
 }
 
 func (server *Server) httpGetConfig(w http.ResponseWriter, r *http.Request) {
-	httputil.RespondJSON(w, server.config)
+	if redacted, err := Redact(server.config); err == nil {
+		httputil.RespondJSON(w, redacted)
+	} else {
+		httputil.RespondJSON(w, err, http.StatusInternalServerError)
+	}
 }
 
 func (server *Server) httpDatasetQuery(w http.ResponseWriter, r *http.Request) {
@@ -81,20 +86,36 @@ func (server *Server) httpDatasetQuery(w http.ResponseWriter, r *http.Request) {
 			}
 		}
 
-		var response, err = DefaultConfig.Datasets.QuerySchema(qname, opts)
+		// the request context is canceled if the client disconnects, which aborts any upstream calls
+		var ctx = r.Context()
+
+		if httputil.QBool(r, `_nocache`) {
+			ctx = WithCacheBypass(ctx)
+		}
+
+		var response, err = DefaultConfig.Datasets.QuerySchemaWithContext(ctx, qname, opts)
 		w.Header().Set(`Content-Type`, `application/json`)
 
 		if err == nil {
 			w.WriteHeader(http.StatusOK)
 		} else {
-			httputil.RespondJSON(w, err, http.StatusInternalServerError)
+			httputil.RespondJSON(w, errors.New(scrubSecrets(err.Error())), http.StatusInternalServerError)
 			return
 		}
 
-		if httputil.QBool(r, `_debug`) {
-			httputil.RespondJSON(w, response)
-		} else if response != nil {
-			httputil.RespondJSON(w, response.Result)
+		if response == nil {
+			return
+		} else if httputil.QBool(r, `_debug`) {
+			// the details of the request are redacted; results only have resolved secrets masked
+			if redacted, err := response.redacted(); err == nil {
+				httputil.RespondJSON(w, redacted)
+			} else {
+				httputil.RespondJSON(w, err, http.StatusInternalServerError)
+			}
+		} else if result, err := response.scrubbedResult(); err == nil {
+			httputil.RespondJSON(w, result)
+		} else {
+			httputil.RespondJSON(w, err, http.StatusInternalServerError)
 		}
 	} else {
 		httputil.RespondJSON(
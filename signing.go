@@ -0,0 +1,447 @@
+package orchestra
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	texttemplate "text/template"
+	"time"
+)
+
+const (
+	DefaultHMACCanonical       = "{{ .method }}\n{{ .path }}\n{{ .query }}\n{{ .timestamp }}\n{{ .body_sha256 }}"
+	DefaultHMACHeader          = `X-Signature`
+	DefaultHMACTimestampHeader = `X-Timestamp`
+	DefaultSigV4Region         = `us-east-1`
+)
+
+// signingTime returns the time requests are signed at.
+var signingTime = time.Now
+
+// HMACConfig describes how to sign requests with an HMAC.  The canonical request is a template
+// rendered with the request's method, url, host, path, query (sorted and encoded), body,
+// body_sha256 (hex), timestamp (Unix seconds), date (RFC 3339), headers (keyed on lowercase name)
+// and the key_id.  The signature is sent in the given header, formatted using a template that can
+// also refer to the signature.
+type HMACConfig struct {
+	Secret          string `yaml:"secret"                     json:"secret"`
+	KeyID           string `yaml:"key_id,omitempty"           json:"key_id,omitempty"`
+	Algorithm       string `yaml:"algorithm,omitempty"        json:"algorithm,omitempty"`
+	Encoding        string `yaml:"encoding,omitempty"         json:"encoding,omitempty"`
+	Canonical       string `yaml:"canonical,omitempty"        json:"canonical,omitempty"`
+	Header          string `yaml:"header,omitempty"           json:"header,omitempty"`
+	Format          string `yaml:"format,omitempty"           json:"format,omitempty"`
+	TimestampHeader string `yaml:"timestamp_header,omitempty" json:"timestamp_header,omitempty"`
+}
+
+// SigV4Config holds the credentials and scope used to sign requests with AWS Signature Version 4.
+type SigV4Config struct {
+	AccessKeyID     string `yaml:"access_key_id"           json:"access_key_id"`
+	SecretAccessKey string `yaml:"secret_access_key"       json:"secret_access_key"`
+	SessionToken    string `yaml:"session_token,omitempty" json:"session_token,omitempty"`
+	Region          string `yaml:"region,omitempty"        json:"region,omitempty"`
+	Service         string `yaml:"service"                 json:"service"`
+}
+
+// sigV4UnsignedHeaders are never included in the signature, as they may be changed in transit.
+var sigV4UnsignedHeaders = map[string]bool{
+	`authorization`:     true,
+	`user-agent`:        true,
+	`x-amzn-trace-id`:   true,
+	`expect`:            true,
+	`connection`:        true,
+	`transfer-encoding`: true,
+}
+
+// signs returns whether this auth configuration signs requests (rather than adding credentials to
+// their headers).
+func (auth *AuthConfig) signs() bool {
+	switch auth.authType() {
+	case HMACAuth, SigV4Auth:
+		return true
+	default:
+		return false
+	}
+}
+
+// sign adds a signature to the fully-built request.
+func (auth *AuthConfig) sign(req *http.Request) error {
+	switch auth.authType() {
+	case HMACAuth:
+		if auth.HMAC == nil {
+			return fmt.Errorf("hmac: no hmac configuration")
+		}
+
+		return auth.HMAC.sign(req)
+	case SigV4Auth:
+		if auth.AWS == nil {
+			return fmt.Errorf("sigv4: no aws configuration")
+		}
+
+		return auth.AWS.sign(req)
+	default:
+		return nil
+	}
+}
+
+func (config *HMACConfig) hash() (func() hash.Hash, error) {
+	switch strings.ToLower(config.Algorithm) {
+	case ``, `sha256`:
+		return sha256.New, nil
+	case `sha1`:
+		return sha1.New, nil
+	case `sha512`:
+		return sha512.New, nil
+	default:
+		return nil, fmt.Errorf("hmac: unsupported algorithm %q", config.Algorithm)
+	}
+}
+
+func (config *HMACConfig) encode(sum []byte) (string, error) {
+	switch strings.ToLower(config.Encoding) {
+	case ``, `hex`:
+		return hex.EncodeToString(sum), nil
+	case `base64`:
+		return base64.StdEncoding.EncodeToString(sum), nil
+	case `base64url`:
+		return base64.RawURLEncoding.EncodeToString(sum), nil
+	default:
+		return ``, fmt.Errorf("hmac: unsupported encoding %q", config.Encoding)
+	}
+}
+
+func (config *HMACConfig) sign(req *http.Request) error {
+	var now = signingTime().UTC()
+	var body, err = requestBody(req)
+
+	if err != nil {
+		return err
+	}
+
+	var newHash func() hash.Hash
+
+	if h, err := config.hash(); err == nil {
+		newHash = h
+	} else {
+		return err
+	}
+
+	var tsHeader = config.TimestampHeader
+
+	if tsHeader == `` {
+		tsHeader = DefaultHMACTimestampHeader
+	}
+
+	req.Header.Set(tsHeader, fmt.Sprintf("%d", now.Unix()))
+
+	var headers = make(map[string]any)
+
+	for k := range req.Header {
+		headers[strings.ToLower(k)] = req.Header.Get(k)
+	}
+
+	var path = req.URL.EscapedPath()
+
+	if path == `` {
+		path = `/`
+	}
+
+	var data = map[string]any{
+		`method`:      req.Method,
+		`url`:         req.URL.String(),
+		`host`:        requestHost(req),
+		`path`:        path,
+		`query`:       req.URL.Query().Encode(),
+		`body`:        string(body),
+		`body_sha256`: sha256Hex(body),
+		`timestamp`:   fmt.Sprintf("%d", now.Unix()),
+		`date`:        now.Format(time.RFC3339),
+		`headers`:     headers,
+		`key_id`:      config.KeyID,
+	}
+
+	var secret string
+
+	if s, err := resolveSecretString(req.Context(), config.Secret); err == nil {
+		secret = s
+	} else {
+		return fmt.Errorf("hmac: %v", err)
+	}
+
+	var canonical = config.Canonical
+
+	if canonical == `` {
+		canonical = DefaultHMACCanonical
+	}
+
+	if c, err := renderSigningTemplate(canonical, data); err == nil {
+		var mac = hmac.New(newHash, []byte(secret))
+
+		mac.Write([]byte(c))
+
+		if sig, err := config.encode(mac.Sum(nil)); err == nil {
+			data[`signature`] = sig
+		} else {
+			return err
+		}
+	} else {
+		return fmt.Errorf("hmac: canonical request: %v", err)
+	}
+
+	var format = config.Format
+	var header = config.Header
+
+	if format == `` {
+		format = `{{ .signature }}`
+	}
+
+	if header == `` {
+		header = DefaultHMACHeader
+	}
+
+	if value, err := renderSigningTemplate(format, data); err == nil {
+		req.Header.Set(header, value)
+	} else {
+		return fmt.Errorf("hmac: format: %v", err)
+	}
+
+	return nil
+}
+
+func (config *SigV4Config) sign(req *http.Request) error {
+	var now = signingTime().UTC()
+	var amzDate = now.Format(`20060102T150405Z`)
+	var day = now.Format(`20060102`)
+	var region = config.Region
+	var service = strings.ToLower(config.Service)
+	var creds = make(map[string]string)
+
+	if region == `` {
+		region = DefaultSigV4Region
+	}
+
+	if service == `` {
+		return fmt.Errorf("sigv4: no service given")
+	}
+
+	for k, v := range map[string]string{
+		`access_key_id`:     config.AccessKeyID,
+		`secret_access_key`: config.SecretAccessKey,
+		`session_token`:     config.SessionToken,
+	} {
+		if value, err := resolveSecretString(req.Context(), v); err == nil {
+			creds[k] = value
+		} else {
+			return fmt.Errorf("sigv4: %v", err)
+		}
+	}
+
+	var body, err = requestBody(req)
+
+	if err != nil {
+		return err
+	}
+
+	var payloadHash = sha256Hex(body)
+
+	req.Header.Del(`Authorization`)
+	req.Header.Set(`X-Amz-Date`, amzDate)
+
+	// S3 requires the payload hash to be sent, and signed, along with the request
+	if service == `s3` {
+		req.Header.Set(`X-Amz-Content-Sha256`, payloadHash)
+	}
+
+	if token := creds[`session_token`]; token != `` {
+		req.Header.Set(`X-Amz-Security-Token`, token)
+	}
+
+	var headers = map[string]string{
+		`host`: requestHost(req),
+	}
+
+	for k, vv := range req.Header {
+		var name = strings.ToLower(k)
+
+		if sigV4UnsignedHeaders[name] {
+			continue
+		}
+
+		var values = make([]string, len(vv))
+
+		for i, v := range vv {
+			values[i] = strings.Join(strings.Fields(v), ` `)
+		}
+
+		headers[name] = strings.Join(values, `,`)
+	}
+
+	var names = make([]string, 0, len(headers))
+	var canonicalHeaders strings.Builder
+
+	for name := range headers {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+
+	for _, name := range names {
+		canonicalHeaders.WriteString(name + `:` + headers[name] + "\n")
+	}
+
+	var signedHeaders = strings.Join(names, `;`)
+
+	var canonical = strings.Join([]string{
+		req.Method,
+		sigV4Path(req.URL, service != `s3`),
+		sigV4Query(req.URL),
+		canonicalHeaders.String(),
+		signedHeaders,
+		payloadHash,
+	}, "\n")
+
+	var scope = day + `/` + region + `/` + service + `/aws4_request`
+
+	var toSign = strings.Join([]string{
+		`AWS4-HMAC-SHA256`,
+		amzDate,
+		scope,
+		sha256Hex([]byte(canonical)),
+	}, "\n")
+
+	var key = []byte(`AWS4` + creds[`secret_access_key`])
+
+	for _, part := range []string{day, region, service, `aws4_request`} {
+		key = hmacSHA256(key, part)
+	}
+
+	req.Header.Set(`Authorization`, fmt.Sprintf(
+		"AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		creds[`access_key_id`],
+		scope,
+		signedHeaders,
+		hex.EncodeToString(hmacSHA256(key, toSign)),
+	))
+
+	return nil
+}
+
+// sigV4Path returns the canonical URI of a request: each path segment URI-encoded, and encoded
+// twice for every service except S3.
+func sigV4Path(u *url.URL, double bool) string {
+	var path = u.Path
+
+	if path == `` {
+		return `/`
+	}
+
+	var segments = strings.Split(path, `/`)
+
+	for i, segment := range segments {
+		segments[i] = sigV4Escape(segment)
+
+		if double {
+			segments[i] = sigV4Escape(segments[i])
+		}
+	}
+
+	return strings.Join(segments, `/`)
+}
+
+// sigV4Query returns the canonical query string of a request: URI-encoded, sorted by name then
+// value.
+func sigV4Query(u *url.URL) string {
+	var pairs []string
+
+	for k, vv := range u.Query() {
+		for _, v := range vv {
+			pairs = append(pairs, sigV4Escape(k)+`=`+sigV4Escape(v))
+		}
+	}
+
+	sort.Strings(pairs)
+
+	return strings.Join(pairs, `&`)
+}
+
+// sigV4Escape percent-encodes every byte except the unreserved characters of RFC 3986.
+func sigV4Escape(s string) string {
+	var out strings.Builder
+
+	for i := 0; i < len(s); i++ {
+		var c = s[i]
+
+		if (c >= 'A' && c <= 'Z') || (c >= 'a' && c <= 'z') || (c >= '0' && c <= '9') || c == '-' || c == '_' || c == '.' || c == '~' {
+			out.WriteByte(c)
+		} else {
+			fmt.Fprintf(&out, "%%%02X", c)
+		}
+	}
+
+	return out.String()
+}
+
+// requestBody returns the body of the given request without consuming it.
+func requestBody(req *http.Request) ([]byte, error) {
+	if req.Body == nil || req.Body == http.NoBody {
+		return nil, nil
+	} else if req.GetBody != nil {
+		if body, err := req.GetBody(); err == nil {
+			defer body.Close()
+			return io.ReadAll(body)
+		} else {
+			return nil, err
+		}
+	}
+
+	var data, err = io.ReadAll(req.Body)
+
+	req.Body.Close()
+	req.Body = io.NopCloser(bytes.NewReader(data))
+
+	return data, err
+}
+
+func requestHost(req *http.Request) string {
+	if req.Host != `` {
+		return req.Host
+	} else {
+		return req.URL.Host
+	}
+}
+
+func renderSigningTemplate(tpl string, data map[string]any) (string, error) {
+	var out strings.Builder
+
+	if t, err := texttemplate.New(``).Option(`missingkey=error`).Parse(tpl); err != nil {
+		return ``, err
+	} else if err := t.Execute(&out, data); err != nil {
+		return ``, err
+	}
+
+	return out.String(), nil
+}
+
+func sha256Hex(data []byte) string {
+	var sum = sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	var mac = hmac.New(sha256.New, key)
+
+	mac.Write([]byte(data))
+
+	return mac.Sum(nil)
+}
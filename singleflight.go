@@ -0,0 +1,99 @@
+package orchestra
+
+import (
+	"context"
+	"sync"
+)
+
+// inflightRequests coalesces identical endpoint requests that are made at the same time.
+var inflightRequests = newFlightGroup()
+
+type flightCall struct {
+	done    chan struct{}
+	value   any
+	meta    QueryContext
+	err     error
+	waiters int
+	cancel  context.CancelFunc
+}
+
+type flightGroup struct {
+	calls map[string]*flightCall
+	lock  sync.Mutex
+}
+
+func newFlightGroup() *flightGroup {
+	return &flightGroup{
+		calls: make(map[string]*flightCall),
+	}
+}
+
+// do calls load once for any number of concurrent callers using the same key, and gives each of
+// them the result.  The shared call is only canceled once every caller waiting on it has gone away.
+// Details recorded by load are copied into each caller's meta, and callers that joined a call
+// already in progress are marked as "shared".
+func (group *flightGroup) do(ctx context.Context, key string, load cacheLoadFunc, meta QueryContext) (any, error) {
+	group.lock.Lock()
+
+	var call, joined = group.calls[key]
+
+	if !joined {
+		// the call is bounded by its waiters rather than by whichever of them happened to start it
+		var callCtx, cancel = context.WithCancel(context.WithoutCancel(ctx))
+
+		call = &flightCall{
+			done:   make(chan struct{}),
+			meta:   make(QueryContext),
+			cancel: cancel,
+		}
+
+		group.calls[key] = call
+
+		go func() {
+			call.value, call.err = load(callCtx, call.meta)
+
+			group.lock.Lock()
+			group.forget(key, call)
+			group.lock.Unlock()
+
+			cancel()
+			close(call.done)
+		}()
+	}
+
+	call.waiters += 1
+	group.lock.Unlock()
+
+	select {
+	case <-call.done:
+		for k, v := range call.meta {
+			meta[k] = v
+		}
+
+		if joined {
+			meta[`shared`] = true
+		}
+
+		return call.value, call.err
+	case <-ctx.Done():
+		group.lock.Lock()
+		call.waiters -= 1
+
+		if call.waiters == 0 {
+			group.forget(key, call)
+			call.cancel()
+		}
+
+		group.lock.Unlock()
+
+		return nil, ctx.Err()
+	}
+}
+
+// forget removes call from the group, unless it has already been replaced by a newer one.  The
+// group's lock must be held.
+func (group *flightGroup) forget(key string, call *flightCall) {
+	if group.calls[key] == call {
+		delete(group.calls, key)
+	}
+}
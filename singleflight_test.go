@@ -0,0 +1,166 @@
+package orchestra
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/ghetzel/go-stockutil/httputil"
+	"github.com/ghetzel/testify/require"
+)
+
+func TestIdenticalRequestsCoalesced(t *testing.T) {
+	var assert = require.New(t)
+	var calls int32
+	var started = make(chan struct{})
+
+	var server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&calls, 1) == 1 {
+			close(started)
+		}
+
+		time.Sleep(100 * time.Millisecond)
+		httputil.RespondJSON(w, []string{r.URL.Query().Get(`q`)})
+	}))
+	defer server.Close()
+
+	var endpoint = &Endpoint{
+		Name: `coalesced`,
+		URL:  server.URL,
+	}
+
+	var wg sync.WaitGroup
+	var shared int32
+
+	for i := 0; i < 5; i++ {
+		wg.Add(1)
+
+		go func(i int) {
+			defer wg.Done()
+
+			var opts = NewQueryOptions()
+			opts.Params[`q`] = `same`
+
+			var ctx = context.Background()
+
+			// an impatient caller joining, then going away, must not cancel the call for everyone else
+			if i == 0 {
+				var cancel context.CancelFunc
+
+				<-started
+				ctx, cancel = context.WithTimeout(ctx, 20*time.Millisecond)
+				defer cancel()
+			}
+
+			var response, err = opts.QueryWithContext(ctx, endpoint)
+
+			if i == 0 {
+				assert.Error(err)
+			} else {
+				assert.NoError(err)
+				assert.Equal([]any{`same`}, response.Result)
+
+				if response.Context[`shared`] == true {
+					atomic.AddInt32(&shared, 1)
+				}
+			}
+		}(i)
+	}
+
+	wg.Wait()
+
+	assert.EqualValues(1, atomic.LoadInt32(&calls))
+	assert.True(atomic.LoadInt32(&shared) >= 3)
+
+	var opts = NewQueryOptions()
+	opts.Params[`q`] = `different`
+
+	var response, err = opts.Query(endpoint)
+	assert.NoError(err)
+	assert.Equal([]any{`different`}, response.Result)
+	assert.EqualValues(2, atomic.LoadInt32(&calls))
+}
+
+func TestFlightGroupCanceledWhenAbandoned(t *testing.T) {
+	var assert = require.New(t)
+	var group = newFlightGroup()
+	var ctx, cancel = context.WithCancel(context.Background())
+	var loadErr = make(chan error, 1)
+
+	go func() {
+		time.Sleep(20 * time.Millisecond)
+		cancel()
+	}()
+
+	var _, err = group.do(ctx, `key`, func(ctx context.Context, meta QueryContext) (any, error) {
+		<-ctx.Done()
+		loadErr <- ctx.Err()
+		return nil, ctx.Err()
+	}, make(QueryContext))
+
+	assert.Error(err)
+
+	select {
+	case err := <-loadErr:
+		assert.Equal(context.Canceled, err)
+	case <-time.After(time.Second):
+		assert.Fail("shared call was not canceled")
+	}
+}
+
+func TestFlightGroupOutlivesStartersDeadline(t *testing.T) {
+	var assert = require.New(t)
+	var group = newFlightGroup()
+	var loading = make(chan struct{})
+	var release = make(chan struct{})
+	var load = func(ctx context.Context, meta QueryContext) (any, error) {
+		close(loading)
+		<-release
+
+		if _, ok := ctx.Deadline(); ok {
+			return nil, fmt.Errorf("shared call has its starter's deadline")
+		}
+
+		return `done`, ctx.Err()
+	}
+
+	// the caller that starts the call gives up first...
+	var starterCtx, cancel = context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+
+	var starterErr = make(chan error, 1)
+
+	go func() {
+		var _, err = group.do(starterCtx, `key`, load, make(QueryContext))
+		starterErr <- err
+	}()
+
+	<-loading
+
+	// ...while another, with no deadline, is still waiting on it
+	var result = make(chan any, 1)
+	var resultErr = make(chan error, 1)
+
+	go func() {
+		var value, err = group.do(context.Background(), `key`, load, make(QueryContext))
+		result <- value
+		resultErr <- err
+	}()
+
+	assert.Equal(context.DeadlineExceeded, <-starterErr)
+
+	close(release)
+
+	select {
+	case value := <-result:
+		assert.NoError(<-resultErr)
+		assert.Equal(`done`, value)
+	case <-time.After(time.Second):
+		assert.Fail("shared call did not complete")
+	}
+}
@@ -0,0 +1,346 @@
+package orchestra
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ghetzel/go-stockutil/typeutil"
+
+	_ "github.com/go-sql-driver/mysql"
+	_ "github.com/jackc/pgx/v5/stdlib"
+	_ "modernc.org/sqlite"
+)
+
+// SQLConfig describes a SQL statement to run against a database instead of making an HTTP request.
+// The statement may refer to named parameters as ":name", which are bound (never interpolated)
+// from the query's params or, failing that, its variables.  Any positional args are rendered as
+// templates (yielding strings) and bound to the driver's own placeholders ("?", or "$1" etc. for
+// Postgres).
+type SQLConfig struct {
+	Driver          string `yaml:"driver"                      json:"driver"`
+	DSN             string `yaml:"dsn"                         json:"dsn"`
+	Statement       string `yaml:"statement"                   json:"statement"`
+	Args            []any  `yaml:"args,omitempty"              json:"args,omitempty"`
+	MaxOpenConns    int    `yaml:"max_open_conns,omitempty"    json:"max_open_conns,omitempty"`
+	MaxIdleConns    int    `yaml:"max_idle_conns,omitempty"    json:"max_idle_conns,omitempty"`
+	ConnMaxLifetime string `yaml:"conn_max_lifetime,omitempty" json:"conn_max_lifetime,omitempty"`
+}
+
+// driverName returns the database/sql driver name for the configured driver.
+func (config *SQLConfig) driverName() (string, error) {
+	switch strings.ToLower(config.Driver) {
+	case `postgres`, `postgresql`, `pgx`:
+		return `pgx`, nil
+	case `mysql`, `mariadb`:
+		return `mysql`, nil
+	case `sqlite`, `sqlite3`:
+		return `sqlite`, nil
+	default:
+		return ``, fmt.Errorf("unsupported SQL driver %q", config.Driver)
+	}
+}
+
+var sqlPools sync.Map
+
+// db returns the named endpoint's connection pool for the given driver and DSN, opening it on first
+// use.  Pools aren't shared between endpoints so that each gets the pool settings it configured.
+func (config *SQLConfig) db(name string, driver string, dsn string) (*sql.DB, error) {
+	var key = name + `|` + driver + `:` + dsn
+
+	if db, ok := sqlPools.Load(key); ok {
+		return db.(*sql.DB), nil
+	}
+
+	if db, err := sql.Open(driver, dsn); err == nil {
+		if config.MaxOpenConns > 0 {
+			db.SetMaxOpenConns(config.MaxOpenConns)
+		}
+
+		if config.MaxIdleConns > 0 {
+			db.SetMaxIdleConns(config.MaxIdleConns)
+		}
+
+		if lifetime := typeutil.Duration(config.ConnMaxLifetime); lifetime > 0 {
+			db.SetConnMaxLifetime(lifetime)
+		}
+
+		if existing, loaded := sqlPools.LoadOrStore(key, db); loaded {
+			db.Close()
+			return existing.(*sql.DB), nil
+		} else {
+			return db, nil
+		}
+	} else {
+		return nil, err
+	}
+}
+
+// bindStatement rewrites ":name" parameters in statement into the driver's positional placeholder
+// syntax, returning the rewritten statement and the names in the order they must be bound.  For
+// drivers using "?" placeholders, any literal "?" is included in that order as an empty name so
+// positional args can be bound alongside the named ones.  Quoted strings and identifiers
+// (including MySQL's backslash escapes and PostgreSQL's dollar-quoted strings), comments, and "::"
+// casts are left untouched.
+func bindStatement(driver string, statement string, offset int) (string, []string) {
+	var out strings.Builder
+	var names []string
+	var runes = []rune(statement)
+
+	var isIdent = func(r rune) bool {
+		return r == '_' || (r >= 'a' && r <= 'z') || (r >= 'A' && r <= 'Z') || (r >= '0' && r <= '9')
+	}
+
+	// dollarTag returns the "$tag$" delimiter starting at i, if there is one
+	var dollarTag = func(i int) string {
+		var j = i + 1
+
+		if j < len(runes) && runes[j] >= '0' && runes[j] <= '9' {
+			return ``
+		}
+
+		for j < len(runes) && isIdent(runes[j]) {
+			j++
+		}
+
+		if j < len(runes) && runes[j] == '$' {
+			return string(runes[i : j+1])
+		}
+
+		return ``
+	}
+
+	for i := 0; i < len(runes); i++ {
+		var r = runes[i]
+
+		switch {
+		case r == '\'' || r == '"' || r == '`':
+			var j = i + 1
+
+			for j < len(runes) && runes[j] != r {
+				if runes[j] == '\\' && driver == `mysql` && r != '`' {
+					j++
+				}
+
+				j++
+			}
+
+			if j >= len(runes) {
+				j = len(runes) - 1
+			}
+
+			out.WriteString(string(runes[i : j+1]))
+			i = j
+		case r == '$' && driver == `pgx` && dollarTag(i) != ``:
+			var tag = []rune(dollarTag(i))
+			var j = i + len(tag)
+
+			for j < len(runes) && !slices.Equal(runes[j:min(j+len(tag), len(runes))], tag) {
+				j++
+			}
+
+			j = min(j+len(tag), len(runes))
+
+			out.WriteString(string(runes[i:j]))
+			i = j - 1
+		case r == '/' && i+1 < len(runes) && runes[i+1] == '*':
+			var j = i + 2
+			var depth = 1
+
+			// PostgreSQL block comments nest
+			for j < len(runes) && depth > 0 {
+				if runes[j] == '*' && j+1 < len(runes) && runes[j+1] == '/' {
+					depth--
+					j += 2
+				} else if driver == `pgx` && runes[j] == '/' && j+1 < len(runes) && runes[j+1] == '*' {
+					depth++
+					j += 2
+				} else {
+					j++
+				}
+			}
+
+			j = min(j, len(runes))
+
+			out.WriteString(string(runes[i:j]))
+			i = j - 1
+		case r == '-' && i+1 < len(runes) && runes[i+1] == '-':
+			var j = i
+
+			for j < len(runes) && runes[j] != '\n' {
+				j++
+			}
+
+			out.WriteString(string(runes[i:j]))
+			i = j - 1
+		case r == '?' && driver != `pgx`:
+			names = append(names, ``)
+			out.WriteRune(r)
+		case r == ':' && i+1 < len(runes) && runes[i+1] == ':':
+			out.WriteString(`::`)
+			i++
+		case r == ':' && i+1 < len(runes) && isIdent(runes[i+1]) && (i == 0 || !isIdent(runes[i-1])):
+			var j = i + 1
+
+			for j < len(runes) && isIdent(runes[j]) {
+				j++
+			}
+
+			names = append(names, string(runes[i+1:j]))
+
+			if driver == `pgx` {
+				fmt.Fprintf(&out, "$%d", offset+len(names))
+			} else {
+				out.WriteString(`?`)
+			}
+
+			i = j - 1
+		default:
+			out.WriteRune(r)
+		}
+	}
+
+	return out.String(), names
+}
+
+// sqlValue converts a scanned column value into a JSON-native value.
+func sqlValue(value any) any {
+	switch v := value.(type) {
+	case []byte:
+		return string(v)
+	case time.Time:
+		return v.Format(time.RFC3339Nano)
+	default:
+		return v
+	}
+}
+
+// retrieveViaSQL runs the endpoint's SQL statement, returning the resulting rows as a list of
+// objects keyed on column name.
+func retrieveViaSQL(ctx context.Context, endpoint *Endpoint, request *Request) (any, error) {
+	var config = endpoint.SQL
+	var params = request.Params
+	var vars = request.Vars
+
+	if config == nil {
+		return nil, fmt.Errorf("sql: endpoint has no sql configuration")
+	}
+
+	var driver, err = config.driverName()
+
+	if err != nil {
+		return nil, err
+	}
+
+	// args are recorded in the context before any secrets within them are resolved
+	var positional = make([]any, 0)
+	var resolvedPositional = make([]any, 0)
+	var args = make([]any, 0)
+	var resolvedArgs = make([]any, 0)
+	var resolvedParams map[string]any
+
+	for _, arg := range config.Args {
+		if resolved, err := request.Resolve(ctx, arg); err == nil {
+			positional = append(positional, request.Render(arg))
+			resolvedPositional = append(resolvedPositional, resolved)
+		} else {
+			return nil, err
+		}
+	}
+
+	if p, err := request.ResolveParams(ctx, params); err == nil {
+		resolvedParams = p
+	} else {
+		return nil, err
+	}
+
+	var statement, names = bindStatement(driver, config.Statement, len(positional))
+
+	if driver == `pgx` {
+		args = append(args, positional...)
+		resolvedArgs = append(resolvedArgs, resolvedPositional...)
+	}
+
+	for _, name := range names {
+		if name == `` {
+			if len(positional) == 0 {
+				return nil, fmt.Errorf("sql: not enough args for statement")
+			}
+
+			args = append(args, positional[0])
+			resolvedArgs = append(resolvedArgs, resolvedPositional[0])
+			positional = positional[1:]
+			resolvedPositional = resolvedPositional[1:]
+		} else if value, ok := params[name]; ok {
+			args = append(args, value)
+			resolvedArgs = append(resolvedArgs, resolvedParams[name])
+		} else if value, ok := vars[name]; ok {
+			args = append(args, value)
+			resolvedArgs = append(resolvedArgs, value)
+		} else {
+			return nil, fmt.Errorf("sql: no param or variable named %q", name)
+		}
+	}
+
+	request.Context[`sql`] = map[string]any{
+		`statement`: statement,
+		`args`:      args,
+	}
+
+	var db *sql.DB
+
+	args = resolvedArgs
+
+	if dsn, err := request.ResolveString(ctx, config.DSN); err != nil {
+		return nil, err
+	} else if d, err := config.db(endpoint.Name, driver, dsn); err == nil {
+		db = d
+	} else {
+		return nil, err
+	}
+
+	var out = make([]any, 0)
+
+	if rows, err := db.QueryContext(ctx, statement, args...); err == nil {
+		defer rows.Close()
+
+		var columns, err = rows.Columns()
+
+		if err != nil {
+			return nil, err
+		}
+
+		for rows.Next() {
+			var values = make([]any, len(columns))
+			var pointers = make([]any, len(columns))
+
+			for i := range values {
+				pointers[i] = &values[i]
+			}
+
+			if err := rows.Scan(pointers...); err != nil {
+				return nil, err
+			}
+
+			var row = make(map[string]any)
+
+			for i, column := range columns {
+				row[column] = sqlValue(values[i])
+			}
+
+			out = append(out, row)
+		}
+
+		if err := rows.Err(); err != nil {
+			return nil, err
+		}
+	} else {
+		return nil, err
+	}
+
+	return out, nil
+}
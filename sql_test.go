@@ -0,0 +1,127 @@
+package orchestra
+
+import (
+	"database/sql"
+	"path/filepath"
+	"testing"
+
+	"github.com/ghetzel/testify/require"
+)
+
+func TestSQLEndpoint(t *testing.T) {
+	var assert = require.New(t)
+	var dsn = filepath.Join(t.TempDir(), `test.db`)
+
+	var setup = &SQLConfig{
+		Driver: `sqlite`,
+		DSN:    dsn,
+	}
+
+	var db, err = setup.db(`setup`, `sqlite`, dsn)
+	assert.NoError(err)
+
+	_, err = db.Exec(`CREATE TABLE people (id INTEGER PRIMARY KEY, name TEXT, team TEXT)`)
+	assert.NoError(err)
+	_, err = db.Exec(`INSERT INTO people (name, team) VALUES ('alice', 'ops'), ('bob', 'dev'), ('carol', 'dev')`)
+	assert.NoError(err)
+
+	var endpoint = &Endpoint{
+		Name: `people`,
+		SQL: &SQLConfig{
+			Driver:       `sqlite3`,
+			DSN:          `{{ .vars.db }}`,
+			Statement:    `SELECT id, name FROM people WHERE team = :team AND name != ':team' AND id > CAST(? AS INTEGER) ORDER BY id`,
+			Args:         []any{`{{ .vars.after }}`},
+			MaxOpenConns: 2,
+		},
+		Variables: map[string]any{
+			`db`:    dsn,
+			`team`:  `ops`,
+			`after`: 0,
+		},
+		ResultFilters: []any{
+			`$.name`,
+		},
+	}
+
+	var opts = NewQueryOptions()
+	opts.Params[`team`] = `dev`
+
+	response, err := opts.Query(endpoint)
+	assert.NoError(err)
+	assert.Equal([]any{`bob`, `carol`}, response.Result)
+
+	// variables are used when no param of the same name is given
+	response, err = NewQueryOptions().Query(endpoint)
+	assert.NoError(err)
+	assert.Equal(`alice`, response.Result)
+
+	endpoint.SQL.Statement = `SELECT * FROM people WHERE name = :missing`
+	_, err = NewQueryOptions().Query(endpoint)
+	assert.Error(err)
+	assert.Contains(err.Error(), `missing`)
+
+	_, err = NewQueryOptions().Query(&Endpoint{
+		SQL: &SQLConfig{
+			Driver: `oracle`,
+		},
+	})
+
+	assert.Error(err)
+	assert.Contains(err.Error(), `unsupported SQL driver`)
+
+	// endpoints sharing a DSN each get a pool with their own settings
+	var narrow, wide *sql.DB
+
+	narrow, err = (&SQLConfig{MaxOpenConns: 1}).db(`narrow`, `sqlite`, dsn)
+	assert.NoError(err)
+
+	wide, err = (&SQLConfig{MaxOpenConns: 8}).db(`wide`, `sqlite`, dsn)
+	assert.NoError(err)
+
+	assert.Equal(1, narrow.Stats().MaxOpenConnections)
+	assert.Equal(8, wide.Stats().MaxOpenConnections)
+}
+
+func TestBindStatement(t *testing.T) {
+	var assert = require.New(t)
+
+	var statement, names = bindStatement(`pgx`, `SELECT x::text FROM t WHERE a = :a AND b = ':b' -- :c
+AND c IN (:c, :a)`, 1)
+
+	assert.Equal(`SELECT x::text FROM t WHERE a = $2 AND b = ':b' -- :c
+AND c IN ($3, $4)`, statement)
+	assert.Equal([]string{`a`, `c`, `a`}, names)
+
+	statement, names = bindStatement(`mysql`, `SELECT * FROM t WHERE a = :a AND b = ? AND c = '?'`, 0)
+	assert.Equal(`SELECT * FROM t WHERE a = ? AND b = ? AND c = '?'`, statement)
+	assert.Equal([]string{`a`, ``}, names)
+
+	for _, tc := range []struct {
+		driver    string
+		statement string
+		expected  string
+		names     []string
+	}{
+		// block comments
+		{`pgx`, `SELECT :a /* :b, ? */ FROM t`, `SELECT $1 /* :b, ? */ FROM t`, []string{`a`}},
+		{`pgx`, `SELECT /* outer /* :b */ :c */ :a`, `SELECT /* outer /* :b */ :c */ $1`, []string{`a`}},
+		{`mysql`, `SELECT /* :b ? */ :a, ?`, `SELECT /* :b ? */ ?, ?`, []string{`a`, ``}},
+		{`sqlite`, `SELECT :a /* unterminated :b`, `SELECT ? /* unterminated :b`, []string{`a`}},
+		// backslash-escaped quotes
+		{`mysql`, `SELECT 'it\'s :b ?', "say \":c\"" FROM t WHERE a = :a`, `SELECT 'it\'s :b ?', "say \":c\"" FROM t WHERE a = ?`, []string{`a`}},
+		{`mysql`, `SELECT '\\' AS slash, :a`, `SELECT '\\' AS slash, ?`, []string{`a`}},
+		{`sqlite`, `SELECT 'C:\' AS dir, :a`, `SELECT 'C:\' AS dir, ?`, []string{`a`}},
+		{`mysql`, `SELECT 'it''s :b', :a`, `SELECT 'it''s :b', ?`, []string{`a`}},
+		// dollar-quoted strings
+		{`pgx`, `SELECT $$ :b 'x $$, :a`, `SELECT $$ :b 'x $$, $1`, []string{`a`}},
+		{`pgx`, `SELECT $fn$ :b $$ :c $fn$, :a`, `SELECT $fn$ :b $$ :c $fn$, $1`, []string{`a`}},
+		{`pgx`, `SELECT $1, :a`, `SELECT $1, $1`, []string{`a`}},
+		{`pgx`, `SELECT $body$ :b`, `SELECT $body$ :b`, nil},
+		{`mysql`, `SELECT $$ :a $$`, `SELECT $$ ? $$`, []string{`a`}},
+	} {
+		var statement, names = bindStatement(tc.driver, tc.statement, 0)
+		assert.Equal(tc.expected, statement, tc.statement)
+		assert.Equal(tc.names, names, tc.statement)
+	}
+}
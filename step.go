@@ -1,14 +1,107 @@
 package orchestra
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"sync"
 
+	"github.com/blues/jsonata-go"
 	"github.com/ghetzel/go-stockutil/maputil"
 	"github.com/ghetzel/go-stockutil/sliceutil"
 	"github.com/ghetzel/go-stockutil/typeutil"
 )
 
+// stepQueryFunc performs a step's (rendered) query against whatever the step retrieves data from.
+type stepQueryFunc func(ctx context.Context, query *QueryOptions) (*QueryResponse, error)
+
+func endpointQuery(endpoint *Endpoint) stepQueryFunc {
+	return func(ctx context.Context, query *QueryOptions) (*QueryResponse, error) {
+		return query.QueryWithContext(ctx, endpoint)
+	}
+}
+
+// schemaQuery calls another named query, passing along only the rendered variables.
+func schemaQuery(name string) stepQueryFunc {
+	return func(ctx context.Context, query *QueryOptions) (*QueryResponse, error) {
+		return callSchema(ctx, name, &QueryOptions{
+			Context: Context{
+				Variables: query.Variables,
+			},
+		})
+	}
+}
+
+// MaxConcurrency caps the number of foreach items any single parallel step will query at once.
+var MaxConcurrency = 16
+
+// MaxWorkers caps the number of foreach items being queried at once across all steps, pipelines and
+// requests in the process, including those of foreach steps nested within other items' queries.
+var MaxWorkers = 64
+
+type workerSlotKey struct{}
+
+var workerPool struct {
+	sync.Mutex
+	size  int
+	slots chan struct{}
+}
+
+// workerSlot is one of the process-wide MaxWorkers slots, held by a foreach worker.
+type workerSlot struct {
+	slots  chan struct{}
+	nested int
+	lock   sync.Mutex
+}
+
+func (slot *workerSlot) release() {
+	<-slot.slots
+}
+
+// suspend gives up the slot while the worker waits on nested foreach items (of which there may be
+// several sets at once), returning a function that takes a slot back once they are all done.
+func (slot *workerSlot) suspend() func() {
+	slot.lock.Lock()
+	defer slot.lock.Unlock()
+
+	if slot.nested++; slot.nested == 1 {
+		slot.release()
+	}
+
+	return func() {
+		slot.lock.Lock()
+		defer slot.lock.Unlock()
+
+		if slot.nested--; slot.nested == 0 {
+			slot.slots <- struct{}{}
+		}
+	}
+}
+
+// acquireWorker waits for one of the process-wide MaxWorkers slots, returning the slot and a context
+// marking it as held by the worker.
+func acquireWorker(ctx context.Context) (context.Context, *workerSlot, error) {
+	workerPool.Lock()
+
+	if workerPool.slots == nil || workerPool.size != MaxWorkers {
+		workerPool.size = MaxWorkers
+		workerPool.slots = make(chan struct{}, max(MaxWorkers, 1))
+	}
+
+	var slot = &workerSlot{
+		slots: workerPool.slots,
+	}
+
+	workerPool.Unlock()
+
+	select {
+	case slot.slots <- struct{}{}:
+		return context.WithValue(ctx, workerSlotKey{}, slot), slot, nil
+	case <-ctx.Done():
+		return nil, nil, ctx.Err()
+	}
+}
+
 type PipelineStep struct {
 	Name         string        `yaml:"name,omitempty"         json:"name,omitempty"`
 	Summary      string        `yaml:"summary,omitempty"      json:"summary,omitempty"`
@@ -20,9 +113,57 @@ type PipelineStep struct {
 	WithContext  bool          `yaml:"with_context,omitempty" json:"with_context,omitempty"`
 	Optional     bool          `yaml:"optional,omitempty"     json:"optional,omitempty"`
 	Parallel     bool          `yaml:"parallel"               json:"parallel"`
+	Concurrency  int           `yaml:"concurrency,omitempty"  json:"concurrency,omitempty"`
+	DependsOn    []string      `yaml:"depends_on,omitempty"   json:"depends_on,omitempty"`
+	When         any           `yaml:"when,omitempty"         json:"when,omitempty"`
+	Timeout      string        `yaml:"timeout,omitempty"      json:"timeout,omitempty"`
+}
+
+// key returns the name of the pipeline result this step's output is stored under.
+func (step *PipelineStep) key() string {
+	if step.ResultTarget != `` {
+		return step.ResultTarget
+	} else if step.Query != nil && step.Query.UseEndpoint != `` {
+		return step.Query.UseEndpoint
+	} else if step.Query != nil && step.Query.Schema != `` {
+		return step.Query.Schema
+	} else {
+		return DefaultResultKey
+	}
+}
+
+// shouldRun evaluates the step's when expression (if any) against the pipeline results and
+// variables, returning false (and why) if the step should be skipped.  The result is interpreted
+// using JSONata's $boolean() rules, with an undefined result counting as false.
+func (step *PipelineStep) shouldRun(root any, vars map[string]any) (bool, string, error) {
+	if typeutil.IsZero(step.When) {
+		return true, ``, nil
+	}
+
+	var expr = toJsonataExpr(step.When)
+
+	if out, err := applyJsonata(root, vars, `$boolean(`+expr+`)`); err == nil {
+		if typeutil.Bool(out) {
+			return true, ``, nil
+		} else {
+			return false, fmt.Sprintf("when: %s is false", expr), nil
+		}
+	} else if errors.Is(err, jsonata.ErrUndefined) {
+		return false, fmt.Sprintf("when: %s is undefined", expr), nil
+	} else {
+		return false, ``, fmt.Errorf("when: %v", err)
+	}
 }
 
 func (step *PipelineStep) Retrieve(parentOptions *QueryOptions, initdata any) (any, QueryContext, error) {
+	return step.RetrieveWithContext(context.Background(), parentOptions, initdata)
+}
+
+// RetrieveWithContext runs the step, bounding all of its requests by ctx and the step's timeout.
+func (step *PipelineStep) RetrieveWithContext(ctx context.Context, parentOptions *QueryOptions, initdata any) (any, QueryContext, error) {
+	var stepCtx, cancel = withTimeout(ctx, step.Timeout)
+	defer cancel()
+
 	var result any = initdata
 	var vars = make(map[string]any)
 	var context = make(QueryContext)
@@ -43,83 +184,47 @@ func (step *PipelineStep) Retrieve(parentOptions *QueryOptions, initdata any) (a
 			return nil, nil, err
 		}
 
-		if endpoint, ok := registeredEndpoints[query.UseEndpoint]; ok && endpoint != nil {
-			var concurrentGroup sync.WaitGroup
-			var concurrentUsed bool
+		var run stepQueryFunc
+
+		if query.UseEndpoint != `` && query.Schema != `` {
+			return nil, nil, fmt.Errorf("cannot query both endpoint %q and schema %q", query.UseEndpoint, query.Schema)
+		} else if endpoint, ok := registeredEndpoints[query.UseEndpoint]; ok && endpoint != nil {
+			run = endpointQuery(endpoint)
+		} else if query.UseEndpoint != `` {
+			return nil, nil, fmt.Errorf("undefined endpoint %q", query.UseEndpoint)
+		} else if query.Schema != `` {
+			run = schemaQuery(query.Schema)
+		}
 
+		if run != nil {
 			if foreach := query.ForEach; foreach != `` {
 				if elements, err := applyJsonata(
 					result,
 					vars,
 					foreach,
 				); err == nil {
-					var accumulatedResults []any
-					var subcontexts []QueryContext
-
 					if !typeutil.IsArray(elements) {
 						return nil, nil, fmt.Errorf("foreach: JSONata query must return an array")
 					}
 
-					for i, el := range sliceutil.Sliceify(elements) {
-						var subvars = maputil.M(vars).MapNative()
-						subvars[`item`] = el
-						subvars[`index`] = i
-
-						subcontexts = append(subcontexts, QueryContext(subvars))
-
-						if renderedQuery, err := query.Render(subvars); err == nil {
-							var res *QueryResponse
-							var rerr error
-
-							if step.Parallel {
-								concurrentUsed = true
-								res, rerr = renderedQuery.QueryConcurrent(&concurrentGroup, endpoint)
-							} else {
-								res, rerr = renderedQuery.Query(endpoint)
-							}
-
-							if rerr == nil {
-								accumulatedResults = append(accumulatedResults, res.Result)
-							} else if step.Optional {
-								continue
-							} else {
-								return nil, nil, rerr
-							}
-						} else {
-							return nil, nil, err
-						}
-					}
-
-					if concurrentUsed {
-						concurrentGroup.Wait()
+					if results, subcontexts, err := step.forEach(stepCtx, query, run, vars, sliceutil.Sliceify(elements)); err == nil {
+						result = results
+						context[`elements`] = subcontexts
+					} else {
+						return nil, nil, err
 					}
-
-					result = accumulatedResults
-					context[`elements`] = subcontexts
 				} else {
 					return nil, nil, fmt.Errorf("foreach: %v", err)
 				}
 			} else if renderedQuery, err := query.Render(result); err == nil {
-				var res *QueryResponse
-				var rerr error
-
-				if step.Parallel {
-					concurrentUsed = true
-					res, rerr = renderedQuery.QueryConcurrent(&concurrentGroup, endpoint)
-				} else {
-					res, rerr = renderedQuery.Query(endpoint)
-				}
-
-				if rerr == nil {
+				if res, err := run(stepCtx, renderedQuery); err == nil {
 					result = res.Result
 				} else {
-					return nil, nil, rerr
+					return nil, nil, err
 				}
 			} else {
 				return nil, nil, err
 			}
-		} else if query.UseEndpoint != `` {
-			return nil, nil, fmt.Errorf("undefined endpoint %q", query.UseEndpoint)
 		}
 	} else if err != nil {
 		return nil, nil, fmt.Errorf("bad query: %v", err)
@@ -133,3 +238,124 @@ func (step *PipelineStep) Retrieve(parentOptions *QueryOptions, initdata any) (a
 
 	return result, context, nil
 }
+
+// concurrency returns how many foreach items this step may query at once.
+func (step *PipelineStep) concurrency() int {
+	var limit = MaxConcurrency
+
+	if limit < 1 {
+		limit = 1
+	}
+
+	if !step.Parallel {
+		return 1
+	} else if step.Concurrency > 0 && step.Concurrency < limit {
+		return step.Concurrency
+	} else {
+		return limit
+	}
+}
+
+// forEach calls run once per element, running up to step.concurrency() queries at a time, each of
+// which also holds one of the process-wide worker slots.  Results are returned in the same order as
+// the elements.  Optional steps collect per-item errors into the element contexts and omit those
+// items from the results; otherwise the first failure cancels any outstanding queries and is
+// returned.
+func (step *PipelineStep) forEach(
+	ctx context.Context,
+	query *QueryOptions,
+	run stepQueryFunc,
+	vars map[string]any,
+	elements []any,
+) ([]any, []QueryContext, error) {
+	// a worker running a nested foreach (e.g.: in a query it called) gives up its slot while the
+	// nested items run, so that they count against the pool without waiting on their own parent
+	if held, ok := ctx.Value(workerSlotKey{}).(*workerSlot); ok {
+		defer held.suspend()()
+	}
+
+	var itemCtx, cancel = context.WithCancel(ctx)
+	defer cancel()
+
+	var results = make([]any, len(elements))
+	var errs = make([]error, len(elements))
+	var subcontexts = make([]QueryContext, len(elements))
+	var sem = make(chan struct{}, step.concurrency())
+	var wg sync.WaitGroup
+	var firstErr error
+	var firstErrOnce sync.Once
+
+	var fail = func(i int, err error) {
+		errs[i] = err
+
+		if !step.Optional {
+			firstErrOnce.Do(func() {
+				firstErr = err
+				cancel()
+			})
+		}
+	}
+
+dispatch:
+	for i, el := range elements {
+		var subvars = maputil.M(vars).MapNative()
+		subvars[`item`] = el
+		subvars[`index`] = i
+
+		subcontexts[i] = QueryContext(subvars)
+
+		var renderedQuery, err = query.Render(subvars)
+
+		if err != nil {
+			fail(i, err)
+			continue
+		}
+
+		select {
+		case sem <- struct{}{}:
+		case <-itemCtx.Done():
+			break dispatch
+		}
+
+		var workerCtx, slot, werr = acquireWorker(itemCtx)
+
+		if werr != nil {
+			<-sem
+			break dispatch
+		}
+
+		wg.Add(1)
+
+		go func(i int, renderedQuery *QueryOptions) {
+			defer wg.Done()
+			defer func() { <-sem }()
+			defer slot.release()
+
+			if res, err := run(workerCtx, renderedQuery); err == nil {
+				results[i] = res.Result
+			} else {
+				fail(i, err)
+			}
+		}(i, renderedQuery)
+	}
+
+	wg.Wait()
+
+	if firstErr != nil {
+		return nil, nil, firstErr
+	} else if err := ctx.Err(); err != nil {
+		return nil, nil, err
+	}
+
+	var accumulatedResults = make([]any, 0, len(elements))
+
+	for i, err := range errs {
+		if err == nil {
+			accumulatedResults = append(accumulatedResults, results[i])
+		} else {
+			subcontexts[i][`error`] = err.Error()
+		}
+	}
+
+	return accumulatedResults, subcontexts, nil
+}
@@ -0,0 +1,231 @@
+package orchestra
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/ghetzel/go-stockutil/httputil"
+	"github.com/ghetzel/go-stockutil/sliceutil"
+	"github.com/ghetzel/testify/require"
+)
+
+func testFanoutServer(inflight *int32, peak *int32) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var n = atomic.AddInt32(inflight, 1)
+		defer atomic.AddInt32(inflight, -1)
+
+		for {
+			var p = atomic.LoadInt32(peak)
+
+			if n <= p || atomic.CompareAndSwapInt32(peak, p, n) {
+				break
+			}
+		}
+
+		var id = strings.TrimPrefix(r.URL.Path, `/`)
+
+		// later items finish first to make sure ordering is preserved
+		time.Sleep(time.Duration(10-len(id)) * 10 * time.Millisecond)
+
+		if strings.HasPrefix(id, `bad`) {
+			httputil.RespondJSON(w, fmt.Errorf("bad item"), http.StatusBadRequest)
+		} else {
+			httputil.RespondJSON(w, id)
+		}
+	}))
+}
+
+func TestStepForEachParallel(t *testing.T) {
+	var assert = require.New(t)
+	var inflight, peak int32
+	var server = testFanoutServer(&inflight, &peak)
+	defer server.Close()
+
+	RegisterEndpoint(`fanout`, &Endpoint{
+		URL: server.URL + `/{{ .vars.id }}`,
+	})
+
+	var step = &PipelineStep{
+		Parallel:    true,
+		Concurrency: 3,
+		Query: &QueryOptions{
+			UseEndpoint:    `fanout`,
+			ForEach:        `ids`,
+			VariablesQuery: `{"id": item}`,
+		},
+	}
+
+	var result, _, err = step.Retrieve(NewQueryOptions(), map[string]any{
+		`ids`: []any{`a`, `bb`, `ccc`, `dddd`, `eeeee`, `ffffff`},
+	})
+
+	assert.NoError(err)
+	assert.Equal([]string{`a`, `bb`, `ccc`, `dddd`, `eeeee`, `ffffff`}, sliceutil.Stringify(result))
+	assert.EqualValues(3, atomic.LoadInt32(&peak))
+}
+
+func TestStepForEachSequential(t *testing.T) {
+	var assert = require.New(t)
+	var inflight, peak int32
+	var server = testFanoutServer(&inflight, &peak)
+	defer server.Close()
+
+	RegisterEndpoint(`fanout-seq`, &Endpoint{
+		URL: server.URL + `/{{ .vars.id }}`,
+	})
+
+	var step = &PipelineStep{
+		Query: &QueryOptions{
+			UseEndpoint:    `fanout-seq`,
+			ForEach:        `ids`,
+			VariablesQuery: `{"id": item}`,
+		},
+	}
+
+	var result, _, err = step.Retrieve(NewQueryOptions(), map[string]any{
+		`ids`: []any{`a`, `bb`, `ccc`},
+	})
+
+	assert.NoError(err)
+	assert.Equal([]string{`a`, `bb`, `ccc`}, sliceutil.Stringify(result))
+	assert.EqualValues(1, atomic.LoadInt32(&peak))
+}
+
+func TestStepForEachErrors(t *testing.T) {
+	var assert = require.New(t)
+	var inflight, peak int32
+	var server = testFanoutServer(&inflight, &peak)
+	defer server.Close()
+
+	RegisterEndpoint(`fanout-errors`, &Endpoint{
+		URL: server.URL + `/{{ .vars.id }}`,
+	})
+
+	var step = &PipelineStep{
+		Parallel: true,
+		Optional: true,
+		Query: &QueryOptions{
+			UseEndpoint:    `fanout-errors`,
+			ForEach:        `ids`,
+			VariablesQuery: `{"id": item}`,
+		},
+	}
+
+	var data = map[string]any{
+		`ids`: []any{`a`, `bad1`, `ccc`, `bad2`},
+	}
+
+	var result, ctx, err = step.Retrieve(NewQueryOptions(), data)
+
+	assert.NoError(err)
+	assert.Equal([]string{`a`, `ccc`}, sliceutil.Stringify(result))
+
+	var elements, ok = ctx[`elements`].([]QueryContext)
+	assert.True(ok)
+	assert.Len(elements, 4)
+	assert.Nil(elements[0][`error`])
+	assert.NotEmpty(elements[1][`error`])
+	assert.NotEmpty(elements[3][`error`])
+
+	step.Optional = false
+
+	_, _, err = step.Retrieve(NewQueryOptions(), data)
+	assert.Error(err)
+}
+
+func TestStepForEachWorkerLimit(t *testing.T) {
+	var assert = require.New(t)
+	var inflight, peak int32
+	var server = testFanoutServer(&inflight, &peak)
+	defer server.Close()
+
+	var maxWorkers = MaxWorkers
+	MaxWorkers = 2
+	t.Cleanup(func() { MaxWorkers = maxWorkers })
+
+	RegisterEndpoint(`fanout-limit`, &Endpoint{
+		URL: server.URL + `/{{ .vars.id }}`,
+	})
+
+	var errs = make(chan error, 2)
+
+	// two steps running at once share the process-wide limit
+	for range 2 {
+		go func() {
+			var step = &PipelineStep{
+				Parallel:    true,
+				Concurrency: 3,
+				Query: &QueryOptions{
+					UseEndpoint:    `fanout-limit`,
+					ForEach:        `ids`,
+					VariablesQuery: `{"id": item}`,
+				},
+			}
+
+			var _, _, err = step.Retrieve(NewQueryOptions(), map[string]any{
+				`ids`: []any{`a`, `bb`, `ccc`, `dddd`, `eeeee`, `ffffff`},
+			})
+
+			errs <- err
+		}()
+	}
+
+	assert.NoError(<-errs)
+	assert.NoError(<-errs)
+	assert.EqualValues(2, atomic.LoadInt32(&peak))
+
+	// a foreach nested within another one's worker counts against the same limit, without deadlocking
+	// while the outer worker waits on it
+	MaxWorkers = 1
+	atomic.StoreInt32(&peak, 0)
+
+	var dataset = NewConfig().Datasets
+	dataset.Queries[`inner`] = &Schema{
+		Pipeline: &Pipeline{
+			Steps: []*PipelineStep{
+				{
+					ResultTarget: `ids`,
+					Parallel:     true,
+					Query: &QueryOptions{
+						UseEndpoint:    `fanout-limit`,
+						ForEach:        `["x", "yy"]`,
+						VariablesQuery: `{"id": prefix & item}`,
+					},
+				},
+			},
+		},
+	}
+
+	dataset.Queries[`outer`] = &Schema{
+		Pipeline: &Pipeline{
+			Steps: []*PipelineStep{
+				{
+					ResultTarget: `nested`,
+					Parallel:     true,
+					Query: &QueryOptions{
+						Schema:         `inner`,
+						ForEach:        `["a", "b"]`,
+						VariablesQuery: `{"prefix": item}`,
+					},
+					Transforms: []any{`ids`},
+				},
+			},
+		},
+	}
+
+	var ctx, cancel = context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	var response, err = dataset.QuerySchemaWithContext(ctx, `outer`, nil)
+	assert.NoError(err)
+	assert.Equal(map[string]any{
+		`nested`: []any{`ax`, `ayy`, `bx`, `byy`},
+	}, response.Result)
+	assert.EqualValues(1, atomic.LoadInt32(&peak))
+}
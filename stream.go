@@ -0,0 +1,321 @@
+package orchestra
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/blues/jsonata-go"
+	"github.com/coder/websocket"
+	"github.com/ghetzel/go-stockutil/typeutil"
+)
+
+type StreamProtocol string
+
+const (
+	SSEStream       StreamProtocol = `sse`
+	WebSocketStream StreamProtocol = `websocket`
+)
+
+// StreamConfig describes how to sample messages from a Server-Sent Events or WebSocket URL.  Once
+// connected, the subscribe message (if any) is rendered as a template and sent to the server, and
+// messages are collected until count messages have been received, the duration has elapsed, the
+// until expression is true for a message (which is included), or the server ends the stream.  Streams
+// with none of these limits are collected for at most DefaultStreamDuration.  Messages that are valid
+// JSON are decoded; all others are returned as strings.
+type StreamConfig struct {
+	Protocol  StreamProtocol `yaml:"protocol,omitempty"  json:"protocol,omitempty"`
+	Subscribe any            `yaml:"subscribe,omitempty" json:"subscribe,omitempty"`
+	Event     string         `yaml:"event,omitempty"     json:"event,omitempty"`
+	Count     int            `yaml:"count,omitempty"     json:"count,omitempty"`
+	Duration  string         `yaml:"duration,omitempty"  json:"duration,omitempty"`
+	Until     any            `yaml:"until,omitempty"     json:"until,omitempty"`
+}
+
+// DefaultStreamDuration bounds collection from streams that don't set a count, duration or until.
+var DefaultStreamDuration = 60 * time.Second
+
+// duration returns how long to collect messages for, if there is a limit on it.
+func (config *StreamConfig) duration() string {
+	if config.Duration == `` && config.Count <= 0 && typeutil.IsZero(config.Until) {
+		return DefaultStreamDuration.String()
+	}
+
+	return config.Duration
+}
+
+// protocol returns the configured protocol, or one inferred from the URL's scheme.
+func (config *StreamConfig) protocol(u *url.URL) StreamProtocol {
+	if config.Protocol != `` {
+		return config.Protocol
+	} else if u.Scheme == `ws` || u.Scheme == `wss` {
+		return WebSocketStream
+	} else {
+		return SSEStream
+	}
+}
+
+// streamCollector accumulates messages and decides when collection is complete.
+type streamCollector struct {
+	config   *StreamConfig
+	vars     map[string]any
+	messages []any
+}
+
+// add decodes and records a message, returning true once no more messages are wanted.
+func (collector *streamCollector) add(data []byte) (bool, error) {
+	var message any
+
+	if err := json.Unmarshal(data, &message); err != nil {
+		message = string(data)
+	}
+
+	collector.messages = append(collector.messages, message)
+
+	if count := collector.config.Count; count > 0 && len(collector.messages) >= count {
+		return true, nil
+	}
+
+	if !typeutil.IsZero(collector.config.Until) {
+		if out, err := applyJsonata(message, collector.vars, `$boolean(`+toJsonataExpr(collector.config.Until)+`)`); err == nil {
+			return typeutil.Bool(out), nil
+		} else if !errors.Is(err, jsonata.ErrUndefined) {
+			return true, fmt.Errorf("until: %v", err)
+		}
+	}
+
+	return false, nil
+}
+
+func subscribeMessage(subscribe any) []byte {
+	switch s := subscribe.(type) {
+	case nil:
+		return nil
+	case string:
+		return []byte(s)
+	default:
+		return []byte(compactJSON(s))
+	}
+}
+
+// retrieveViaStream connects to a streaming endpoint and returns the list of messages collected.
+func retrieveViaStream(ctx context.Context, endpoint *Endpoint, request *Request) (any, error) {
+	var config = endpoint.Stream
+	var streamURL *url.URL
+
+	if config == nil {
+		config = new(StreamConfig)
+	}
+
+	var params, err = request.ResolveParams(ctx, request.Params)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if rawURL, err := request.ResolveString(ctx, endpoint.URL); err != nil {
+		return nil, err
+	} else if u, err := url.Parse(rawURL); err == nil {
+		var qs = u.Query()
+
+		for k, v := range params {
+			qs.Set(k, typeutil.String(v))
+		}
+
+		u.RawQuery = qs.Encode()
+		streamURL = u
+	} else {
+		return nil, err
+	}
+
+	var header = make(http.Header)
+	var headers map[string]any
+
+	if h, err := request.ResolveHeaders(ctx, request.Headers); err != nil {
+		return nil, err
+	} else if h, err := endpoint.authorize(ctx, h); err == nil {
+		headers = h
+	} else {
+		return nil, err
+	}
+
+	for k, v := range headers {
+		header.Set(k, typeutil.String(v))
+	}
+
+	var collectCtx, cancel = withTimeout(ctx, config.duration())
+	defer cancel()
+
+	var collector = &streamCollector{
+		config:   config,
+		vars:     request.Vars,
+		messages: make([]any, 0),
+	}
+
+	var protocol = config.protocol(streamURL)
+	var subscribe []byte
+
+	if s := subscribeMessage(request.Render(config.Subscribe)); s != nil {
+		request.Context[`subscribe`] = string(s)
+	}
+
+	if resolved, err := request.Resolve(ctx, config.Subscribe); err == nil {
+		subscribe = subscribeMessage(resolved)
+	} else {
+		return nil, err
+	}
+
+	var client *http.Client
+
+	if c, err := endpoint.httpClient(ctx); err == nil {
+		client = c
+	} else {
+		return nil, err
+	}
+
+	switch protocol {
+	case SSEStream:
+		err = collectSSE(collectCtx, endpoint, client, streamURL, header, subscribe, collector)
+	case WebSocketStream:
+		err = collectWebSocket(collectCtx, client, streamURL, header, subscribe, collector)
+	default:
+		err = fmt.Errorf("unsupported stream protocol %q", protocol)
+	}
+
+	// the collection window elapsing is how duration-limited streams normally end
+	if err != nil && ctx.Err() == nil && collectCtx.Err() != nil {
+		err = nil
+	}
+
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", protocol, err)
+	}
+
+	request.Context[`messages`] = len(collector.messages)
+
+	return collector.messages, nil
+}
+
+// collectSSE reads a text/event-stream response, passing the data of each event (of the configured
+// type, if any) to the collector.  A subscribe message is sent as the request body.
+func collectSSE(ctx context.Context, endpoint *Endpoint, client *http.Client, u *url.URL, header http.Header, subscribe []byte, collector *streamCollector) error {
+	var method = strings.ToUpper(endpoint.Method)
+	var body io.Reader
+
+	if subscribe != nil {
+		body = bytes.NewReader(subscribe)
+
+		if method == `` {
+			method = http.MethodPost
+		}
+	} else if method == `` {
+		method = http.MethodGet
+	}
+
+	var req, err = http.NewRequestWithContext(ctx, method, u.String(), body)
+
+	if err != nil {
+		return err
+	}
+
+	req.Header = header
+	req.Header.Set(`Accept`, `text/event-stream`)
+
+	var response *http.Response
+
+	if r, err := client.Do(req); err == nil {
+		response = r
+	} else {
+		return err
+	}
+
+	defer response.Body.Close()
+
+	if response.StatusCode >= 400 {
+		return fmt.Errorf("HTTP %v", response.Status)
+	}
+
+	var scanner = bufio.NewScanner(response.Body)
+	var event string
+	var data [][]byte
+
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+
+	for scanner.Scan() {
+		var line = scanner.Text()
+
+		if line == `` {
+			if len(data) > 0 && (collector.config.Event == `` || collector.config.Event == event) {
+				if done, err := collector.add(bytes.Join(data, []byte("\n"))); err != nil || done {
+					return err
+				}
+			}
+
+			event = ``
+			data = nil
+			continue
+		} else if strings.HasPrefix(line, `:`) {
+			continue
+		}
+
+		var field, value, _ = strings.Cut(line, `:`)
+		value = strings.TrimPrefix(value, ` `)
+
+		switch field {
+		case `event`:
+			event = value
+		case `data`:
+			data = append(data, []byte(value))
+		}
+	}
+
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+
+	return ctx.Err()
+}
+
+// collectWebSocket passes each message received over a WebSocket to the collector, after sending the
+// subscribe message (if any).
+func collectWebSocket(ctx context.Context, client *http.Client, u *url.URL, header http.Header, subscribe []byte, collector *streamCollector) error {
+	var conn, _, err = websocket.Dial(ctx, u.String(), &websocket.DialOptions{
+		HTTPClient: client,
+		HTTPHeader: header,
+	})
+
+	if err != nil {
+		return err
+	}
+
+	defer conn.CloseNow()
+
+	if subscribe != nil {
+		if err := conn.Write(ctx, websocket.MessageText, subscribe); err != nil {
+			return err
+		}
+	}
+
+	for {
+		if _, data, err := conn.Read(ctx); err == nil {
+			if done, err := collector.add(data); err != nil {
+				return err
+			} else if done {
+				conn.Close(websocket.StatusNormalClosure, ``)
+				return nil
+			}
+		} else if status := websocket.CloseStatus(err); status == websocket.StatusNormalClosure || status == websocket.StatusGoingAway {
+			return nil
+		} else {
+			return err
+		}
+	}
+}
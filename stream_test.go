@@ -0,0 +1,194 @@
+package orchestra
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/coder/websocket"
+	"github.com/ghetzel/testify/require"
+)
+
+func TestSSEStreamEndpoint(t *testing.T) {
+	var assert = require.New(t)
+
+	var server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set(`Content-Type`, `text/event-stream`)
+
+		var body, _ = io.ReadAll(r.Body)
+		var topic = r.URL.Query().Get(`topic`)
+
+		if len(body) > 0 {
+			topic = string(body)
+		}
+
+		for i := 1; ; i++ {
+			select {
+			case <-r.Context().Done():
+				return
+			default:
+			}
+
+			fmt.Fprintf(w, ": keepalive\nevent: ping\ndata: ignored\n\n")
+			fmt.Fprintf(w, "event: tick\ndata: {\"topic\": %q,\ndata: \"n\": %d}\n\n", topic, i)
+			w.(http.Flusher).Flush()
+			time.Sleep(5 * time.Millisecond)
+		}
+	}))
+	defer server.Close()
+
+	var opts = NewQueryOptions()
+	opts.Params[`topic`] = `prices`
+
+	var response, err = opts.Query(&Endpoint{
+		URL: server.URL,
+		Stream: &StreamConfig{
+			Event: `tick`,
+			Count: 3,
+		},
+	})
+
+	assert.NoError(err)
+	assert.Equal([]any{
+		map[string]any{`topic`: `prices`, `n`: float64(1)},
+		map[string]any{`topic`: `prices`, `n`: float64(2)},
+		map[string]any{`topic`: `prices`, `n`: float64(3)},
+	}, response.Result)
+
+	// a subscribe message is posted as the request body
+	response, err = NewQueryOptions().Query(&Endpoint{
+		URL: server.URL,
+		Stream: &StreamConfig{
+			Subscribe: `orders`,
+			Event:     `tick`,
+			Until:     `n >= 2`,
+		},
+		ResultFilters: []any{
+			`topic`,
+		},
+	})
+
+	assert.NoError(err)
+	assert.Equal([]any{`orders`, `orders`}, response.Result)
+
+	// duration windows end collection without an error
+	response, err = NewQueryOptions().Query(&Endpoint{
+		URL: server.URL,
+		Stream: &StreamConfig{
+			Duration: `50ms`,
+		},
+	})
+
+	assert.NoError(err)
+	assert.True(response.Context[`messages`].(int) > 1)
+
+	// streams without any limits are capped at the default duration
+	var defaultDuration = DefaultStreamDuration
+	DefaultStreamDuration = 50 * time.Millisecond
+	t.Cleanup(func() { DefaultStreamDuration = defaultDuration })
+
+	response, err = NewQueryOptions().Query(&Endpoint{
+		URL:    server.URL,
+		Stream: &StreamConfig{},
+	})
+
+	assert.NoError(err)
+	assert.True(response.Context[`messages`].(int) > 1)
+}
+
+func TestSSEStreamIncompleteEvent(t *testing.T) {
+	var assert = require.New(t)
+
+	// the last event is cut off before the blank line that would complete it
+	var server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set(`Content-Type`, `text/event-stream`)
+		fmt.Fprintf(w, "data: 1\n\nevent: skip\ndata: 2\n\ndata: {\"n\":\ndata: 3}")
+	}))
+	defer server.Close()
+
+	var response, err = NewQueryOptions().Query(&Endpoint{
+		URL:    server.URL,
+		Stream: &StreamConfig{},
+	})
+
+	assert.NoError(err)
+	assert.Equal([]any{float64(1), float64(2)}, response.Result)
+
+	response, err = NewQueryOptions().Query(&Endpoint{
+		URL: server.URL,
+		Stream: &StreamConfig{
+			Until: `n = 3`,
+		},
+	})
+
+	assert.NoError(err)
+	assert.Equal([]any{float64(1), float64(2)}, response.Result)
+}
+
+func TestWebSocketStreamEndpoint(t *testing.T) {
+	var assert = require.New(t)
+
+	var server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var conn, err = websocket.Accept(w, r, nil)
+
+		if err != nil {
+			return
+		}
+
+		defer conn.CloseNow()
+
+		var ctx = context.Background()
+		var _, subscribe, _ = conn.Read(ctx)
+
+		for i := 1; i <= 5; i++ {
+			if err := conn.Write(ctx, websocket.MessageText, []byte(fmt.Sprintf(`{"channel": %s, "seq": %d}`, subscribe, i))); err != nil {
+				return
+			}
+		}
+
+		conn.Write(ctx, websocket.MessageText, []byte(`not json`))
+		conn.Close(websocket.StatusNormalClosure, ``)
+	}))
+	defer server.Close()
+
+	var wsURL = `ws` + strings.TrimPrefix(server.URL, `http`)
+	var opts = NewQueryOptions()
+	opts.Variables = map[string]any{
+		`channel`: `trades`,
+	}
+
+	var response, err = opts.Query(&Endpoint{
+		URL: wsURL,
+		Stream: &StreamConfig{
+			Subscribe: `"{{ .vars.channel }}"`,
+			Until:     `seq = 3`,
+		},
+	})
+
+	assert.NoError(err)
+	assert.Equal([]any{
+		map[string]any{`channel`: `trades`, `seq`: float64(1)},
+		map[string]any{`channel`: `trades`, `seq`: float64(2)},
+		map[string]any{`channel`: `trades`, `seq`: float64(3)},
+	}, response.Result)
+	assert.Equal(`"trades"`, response.Context[`subscribe`])
+
+	// with no limits, messages are collected until the server closes the stream
+	response, err = opts.Query(&Endpoint{
+		URL: wsURL,
+		Stream: &StreamConfig{
+			Subscribe: `"all"`,
+		},
+		ResultFilters: []any{
+			`$count($)`,
+		},
+	})
+
+	assert.NoError(err)
+	assert.EqualValues(6, response.Result)
+}
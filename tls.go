@@ -0,0 +1,189 @@
+package orchestra
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/ghetzel/go-stockutil/fileutil"
+)
+
+// TLSConfig describes how to make TLS connections to an endpoint.  The cert, key and ca may each be
+// given as PEM data or as the path to a PEM file (relative paths are resolved against each directory
+// in DatasetsPath), and may refer to secrets.  A CA bundle replaces the system's trusted roots.
+// Certificates are loaded when the endpoint is first used, and its client is reused thereafter.
+type TLSConfig struct {
+	Cert               string `yaml:"cert,omitempty"                 json:"cert,omitempty"`
+	Key                string `yaml:"key,omitempty"                  json:"key,omitempty"`
+	CA                 string `yaml:"ca,omitempty"                   json:"ca,omitempty"`
+	ServerName         string `yaml:"server_name,omitempty"          json:"server_name,omitempty"`
+	MinVersion         string `yaml:"min_version,omitempty"          json:"min_version,omitempty"`
+	InsecureSkipVerify bool   `yaml:"insecure_skip_verify,omitempty" json:"insecure_skip_verify,omitempty"`
+}
+
+var tlsVersions = map[string]uint16{
+	`1.0`: tls.VersionTLS10,
+	`1.1`: tls.VersionTLS11,
+	`1.2`: tls.VersionTLS12,
+	`1.3`: tls.VersionTLS13,
+}
+
+var tlsClients sync.Map
+var tlsClientsLock sync.Mutex
+
+// key identifies TLS configurations that can share a client.
+func (config *TLSConfig) key() string {
+	if config == nil {
+		return ``
+	}
+
+	return requestKey(map[string]any{
+		`tls`: config,
+	})
+}
+
+// build loads the certificates and returns the resulting TLS configuration.
+func (config *TLSConfig) build(ctx context.Context) (*tls.Config, error) {
+	var tc = &tls.Config{
+		ServerName:         config.ServerName,
+		InsecureSkipVerify: config.InsecureSkipVerify,
+	}
+
+	if v := config.MinVersion; v != `` {
+		if version, ok := tlsVersions[strings.TrimPrefix(strings.ToLower(v), `tls`)]; ok {
+			tc.MinVersion = version
+		} else {
+			return nil, fmt.Errorf("tls: unsupported min_version %q", v)
+		}
+	}
+
+	if config.CA != `` {
+		if data, err := readPEM(ctx, config.CA); err == nil {
+			tc.RootCAs = x509.NewCertPool()
+
+			if !tc.RootCAs.AppendCertsFromPEM(data) {
+				return nil, fmt.Errorf("tls: ca: no certificates found")
+			}
+		} else {
+			return nil, fmt.Errorf("tls: ca: %v", err)
+		}
+	}
+
+	if config.Cert != `` || config.Key != `` {
+		if config.Cert == `` || config.Key == `` {
+			return nil, fmt.Errorf("tls: cert and key must be given together")
+		} else if cert, err := readPEM(ctx, config.Cert); err != nil {
+			return nil, fmt.Errorf("tls: cert: %v", err)
+		} else if key, err := readPEM(ctx, config.Key); err != nil {
+			return nil, fmt.Errorf("tls: key: %v", err)
+		} else if pair, err := tls.X509KeyPair(cert, key); err == nil {
+			tc.Certificates = []tls.Certificate{pair}
+		} else {
+			return nil, fmt.Errorf("tls: %v", err)
+		}
+	}
+
+	return tc, nil
+}
+
+// tlsConfig returns the TLS configuration for connecting to this endpoint, or nil if it has none.
+func (endpoint *Endpoint) tlsConfig(ctx context.Context) (*tls.Config, error) {
+	if endpoint.TLS == nil {
+		return nil, nil
+	}
+
+	if client, err := endpoint.httpClient(ctx); err == nil {
+		return client.Transport.(*http.Transport).TLSClientConfig, nil
+	} else {
+		return nil, err
+	}
+}
+
+// httpClient returns the HTTP client used to connect to this endpoint.  Endpoints with the same TLS
+// configuration share a client (and its connections); those without one use http.DefaultClient.
+func (endpoint *Endpoint) httpClient(ctx context.Context) (*http.Client, error) {
+	if endpoint.TLS == nil {
+		return http.DefaultClient, nil
+	}
+
+	var key = endpoint.TLS.key()
+
+	if client, ok := tlsClients.Load(key); ok {
+		return client.(*http.Client), nil
+	}
+
+	tlsClientsLock.Lock()
+	defer tlsClientsLock.Unlock()
+
+	if client, ok := tlsClients.Load(key); ok {
+		return client.(*http.Client), nil
+	}
+
+	if tc, err := endpoint.TLS.build(ctx); err == nil {
+		var transport = http.DefaultTransport.(*http.Transport).Clone()
+		var client = &http.Client{
+			Transport: transport,
+		}
+
+		transport.TLSClientConfig = tc
+		tlsClients.Store(key, client)
+
+		return client, nil
+	} else {
+		return nil, err
+	}
+}
+
+// readPEM returns the given PEM data, or the contents of the file it names.
+func readPEM(ctx context.Context, value string) ([]byte, error) {
+	if v, err := resolveSecretString(ctx, value); err == nil {
+		value = v
+	} else {
+		return nil, err
+	}
+
+	if strings.Contains(value, `-----BEGIN `) {
+		return []byte(value), nil
+	}
+
+	var data, _, err = readDatasetFile(value)
+	return data, err
+}
+
+// readDatasetFile reads a file, resolving relative paths against each directory in DatasetsPath
+// (then the working directory).  It returns the contents and the path that was read.
+func readDatasetFile(path string) ([]byte, string, error) {
+	var candidates []string
+
+	if p, err := fileutil.ExpandUser(path); err == nil {
+		path = p
+	} else {
+		return nil, ``, err
+	}
+
+	if filepath.IsAbs(path) {
+		candidates = []string{path}
+	} else {
+		for _, dir := range DatasetsPath {
+			candidates = append(candidates, filepath.Join(dir, path))
+		}
+
+		candidates = append(candidates, path)
+	}
+
+	for _, candidate := range candidates {
+		if data, err := os.ReadFile(candidate); err == nil {
+			return data, candidate, nil
+		} else if !os.IsNotExist(err) {
+			return nil, candidate, err
+		}
+	}
+
+	return nil, ``, fmt.Errorf("%q not found", path)
+}
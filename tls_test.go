@@ -0,0 +1,177 @@
+package orchestra
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/ghetzel/go-stockutil/httputil"
+	"github.com/ghetzel/testify/require"
+)
+
+type testCert struct {
+	cert    *x509.Certificate
+	key     *ecdsa.PrivateKey
+	certPEM []byte
+	keyPEM  []byte
+}
+
+// newTestCert creates a certificate for the given name, signed by parent (or self-signed if parent
+// is nil).
+func newTestCert(t *testing.T, name string, parent *testCert, usage x509.ExtKeyUsage) *testCert {
+	var key, err = ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+
+	var template = &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: name},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		DNSNames:     []string{name},
+		KeyUsage:     x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:  []x509.ExtKeyUsage{usage},
+	}
+
+	var signer, signerKey = template, key
+
+	if parent == nil {
+		template.IsCA = true
+		template.BasicConstraintsValid = true
+	} else {
+		signer, signerKey = parent.cert, parent.key
+	}
+
+	var der []byte
+	der, err = x509.CreateCertificate(rand.Reader, template, signer, &key.PublicKey, signerKey)
+	require.NoError(t, err)
+
+	var out = &testCert{key: key}
+
+	out.cert, err = x509.ParseCertificate(der)
+	require.NoError(t, err)
+
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	require.NoError(t, err)
+
+	out.certPEM = pem.EncodeToMemory(&pem.Block{Type: `CERTIFICATE`, Bytes: der})
+	out.keyPEM = pem.EncodeToMemory(&pem.Block{Type: `EC PRIVATE KEY`, Bytes: keyDER})
+
+	return out
+}
+
+func TestEndpointMutualTLS(t *testing.T) {
+	var assert = require.New(t)
+	var dir = t.TempDir()
+
+	var ca = newTestCert(t, `Test CA`, nil, x509.ExtKeyUsageAny)
+	var serverCert = newTestCert(t, `internal.test`, ca, x509.ExtKeyUsageServerAuth)
+	var clientCert = newTestCert(t, `orchestra`, ca, x509.ExtKeyUsageClientAuth)
+
+	assert.NoError(os.WriteFile(filepath.Join(dir, `ca.pem`), ca.certPEM, 0600))
+	assert.NoError(os.WriteFile(filepath.Join(dir, `client.pem`), clientCert.certPEM, 0600))
+	assert.NoError(os.WriteFile(filepath.Join(dir, `client.key`), clientCert.keyPEM, 0600))
+
+	var clientCAs = x509.NewCertPool()
+	clientCAs.AddCert(ca.cert)
+
+	var server = httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		httputil.RespondJSON(w, map[string]any{
+			`client`: r.TLS.PeerCertificates[0].Subject.CommonName,
+		})
+	}))
+
+	server.TLS = &tls.Config{
+		Certificates: []tls.Certificate{{
+			Certificate: [][]byte{serverCert.cert.Raw},
+			PrivateKey:  serverCert.key,
+		}},
+		ClientAuth: tls.RequireAndVerifyClientCert,
+		ClientCAs:  clientCAs,
+		MaxVersion: tls.VersionTLS12,
+	}
+
+	server.StartTLS()
+	defer server.Close()
+
+	t.Setenv(`ORCHESTRA_TEST_CLIENT_KEY`, string(clientCert.keyPEM))
+
+	var endpoint = &Endpoint{
+		URL: server.URL,
+		TLS: &TLSConfig{
+			Cert:       filepath.Join(dir, `client.pem`),
+			Key:        `${env:ORCHESTRA_TEST_CLIENT_KEY}`,
+			CA:         filepath.Join(dir, `ca.pem`),
+			ServerName: `internal.test`,
+		},
+	}
+
+	var response, err = NewQueryOptions().Query(endpoint)
+	assert.NoError(err)
+	assert.Equal(map[string]any{`client`: `orchestra`}, response.Result)
+
+	// the client (and its connection pool) is reused
+	var first, _ = endpoint.httpClient(context.Background())
+	var second, _ = (&Endpoint{TLS: &TLSConfig{
+		Cert:       filepath.Join(dir, `client.pem`),
+		Key:        `${env:ORCHESTRA_TEST_CLIENT_KEY}`,
+		CA:         filepath.Join(dir, `ca.pem`),
+		ServerName: `internal.test`,
+	}}).httpClient(context.Background())
+
+	assert.True(first == second)
+
+	for _, config := range []*TLSConfig{
+		// no client certificate
+		{CA: filepath.Join(dir, `ca.pem`), ServerName: `internal.test`},
+		// server certificate doesn't match the address
+		{Cert: filepath.Join(dir, `client.pem`), Key: filepath.Join(dir, `client.key`), CA: filepath.Join(dir, `ca.pem`)},
+		// untrusted CA
+		{Cert: filepath.Join(dir, `client.pem`), Key: filepath.Join(dir, `client.key`), ServerName: `internal.test`},
+		// server doesn't support the minimum version
+		{Cert: filepath.Join(dir, `client.pem`), Key: filepath.Join(dir, `client.key`), CA: filepath.Join(dir, `ca.pem`), ServerName: `internal.test`, MinVersion: `1.3`},
+	} {
+		_, err = NewQueryOptions().Query(&Endpoint{URL: server.URL, TLS: config})
+		assert.Error(err)
+	}
+
+	// verification can be skipped, but client certificates are still sent
+	response, err = NewQueryOptions().Query(&Endpoint{
+		URL: server.URL,
+		TLS: &TLSConfig{
+			Cert:               string(clientCert.certPEM),
+			Key:                filepath.Join(dir, `client.key`),
+			InsecureSkipVerify: true,
+		},
+	})
+
+	assert.NoError(err)
+	assert.Equal(map[string]any{`client`: `orchestra`}, response.Result)
+
+	_, err = NewQueryOptions().Query(&Endpoint{
+		URL: server.URL,
+		TLS: &TLSConfig{Cert: filepath.Join(dir, `client.pem`)},
+	})
+
+	assert.Error(err)
+	assert.Contains(err.Error(), `cert and key must be given together`)
+
+	_, err = NewQueryOptions().Query(&Endpoint{
+		URL: server.URL,
+		TLS: &TLSConfig{MinVersion: `1.4`},
+	})
+
+	assert.Error(err)
+	assert.Contains(err.Error(), `unsupported min_version "1.4"`)
+}
@@ -0,0 +1,195 @@
+package orchestra
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"strings"
+	"sync"
+)
+
+// Request is an endpoint query after the endpoint's and query's headers, params and variables have
+// been merged and its URL has been rendered.  Secret references are left unresolved; transports
+// resolve them (using the Resolve methods) just before sending.
+type Request struct {
+	URL     string         `json:"url"`
+	Headers map[string]any `json:"headers,omitempty"`
+	Params  map[string]any `json:"params,omitempty"`
+	Vars    map[string]any `json:"vars,omitempty"`
+	Query   *QueryOptions  `json:"-"`
+
+	// Context is the data templates are rendered against, and is returned as the query response's
+	// context; transports may record details about the request here.
+	Context QueryContext `json:"-"`
+
+	// the names of the headers and params whose values came from the endpoint's configuration, and
+	// the (lowercase) names of those marked as sensitive
+	configHeaders map[string]bool
+	configParams  map[string]bool
+	sensitive     map[string]bool
+}
+
+// Render renders any templates within value against the request's context.
+func (request *Request) Render(value any) any {
+	return renderTemplates(value, request.Context)
+}
+
+// Resolve renders value, which must come from the endpoint's configuration, against the request's
+// context after resolving any secret references within it.  References are resolved before
+// rendering so that values from variables, params and other steps' results are never resolved.
+func (request *Request) Resolve(ctx context.Context, value any) (any, error) {
+	if resolved, err := resolveSecretTemplates(ctx, value); err == nil {
+		return request.Render(resolved), nil
+	} else {
+		return nil, err
+	}
+}
+
+// ResolveString is like Resolve, but renders the string in the same way as the endpoint's URL.
+func (request *Request) ResolveString(ctx context.Context, format string) (string, error) {
+	if resolved, err := resolveSecretRefs(ctx, format, true); err == nil {
+		return FormatString(resolved, request.Context), nil
+	} else {
+		return ``, err
+	}
+}
+
+// ResolveHeaders returns a copy of headers with the secret references resolved in those whose values
+// came from the endpoint's configuration.
+func (request *Request) ResolveHeaders(ctx context.Context, headers map[string]any) (map[string]any, error) {
+	return resolveConfigValues(ctx, headers, request.configHeaders)
+}
+
+// ResolveParams returns a copy of params with the secret references resolved in those whose values
+// came from the endpoint's configuration.
+func (request *Request) ResolveParams(ctx context.Context, params map[string]any) (map[string]any, error) {
+	return resolveConfigValues(ctx, params, request.configParams)
+}
+
+func resolveConfigValues(ctx context.Context, values map[string]any, fromConfig map[string]bool) (map[string]any, error) {
+	var out = make(map[string]any, len(values))
+
+	for k, v := range values {
+		if fromConfig[k] {
+			if resolved, err := ResolveSecrets(ctx, v); err == nil {
+				v = resolved
+			} else {
+				return nil, err
+			}
+		}
+
+		out[k] = v
+	}
+
+	return out, nil
+}
+
+// Body returns the body to send: the query's body if it has one, or else the endpoint's.  The body is
+// recorded in the request's context, with any secret references (which are only resolved within a
+// static body from configuration) left unresolved.
+func (request *Request) Body(ctx context.Context, endpoint *Endpoint) (any, error) {
+	var query = request.Query
+
+	if query == nil {
+		query = new(QueryOptions)
+	}
+
+	if body, static, err := query.renderRequestBody(endpoint, request.Context, request.Vars); err != nil {
+		return nil, err
+	} else if body == nil {
+		return nil, nil
+	} else {
+		request.Context[`body`] = body
+
+		if static {
+			var source, _ = query.staticBody(endpoint)
+			return request.Resolve(ctx, source)
+		}
+
+		return body, nil
+	}
+}
+
+// Transport retrieves data for an endpoint.  The result is passed through the endpoint's filters
+// and the query's transforms by the caller.
+type Transport interface {
+	Do(ctx context.Context, endpoint *Endpoint, request *Request) (any, error)
+}
+
+// TransportFunc adapts a function to the Transport interface.
+type TransportFunc func(ctx context.Context, endpoint *Endpoint, request *Request) (any, error)
+
+func (fn TransportFunc) Do(ctx context.Context, endpoint *Endpoint, request *Request) (any, error) {
+	return fn(ctx, endpoint, request)
+}
+
+// DefaultTransport is the name of the transport used by endpoints that don't specify a kind and
+// whose URL scheme has no registered transport.
+const DefaultTransport = `http`
+
+var registeredTransports = map[string]Transport{
+	`http`:   TransportFunc(retrieveViaURL),
+	`https`:  TransportFunc(retrieveViaURL),
+	`file`:   TransportFunc(retrieveViaFile),
+	`sql`:    TransportFunc(retrieveViaSQL),
+	`exec`:   TransportFunc(retrieveViaExec),
+	`grpc`:   TransportFunc(retrieveViaGRPC),
+	`stream`: TransportFunc(retrieveViaStream),
+	`ws`:     TransportFunc(retrieveViaStream),
+	`wss`:    TransportFunc(retrieveViaStream),
+}
+
+var transportsLock sync.RWMutex
+
+// RegisterTransport makes a transport available to endpoints whose kind, or whose URL scheme, is
+// the given name.  Registering a name again replaces the existing transport (including built-in
+// ones).
+func RegisterTransport(name string, transport Transport) {
+	transportsLock.Lock()
+	defer transportsLock.Unlock()
+
+	registeredTransports[strings.ToLower(name)] = transport
+}
+
+// GetTransport returns the transport registered under the given name.
+func GetTransport(name string) (Transport, bool) {
+	transportsLock.RLock()
+	defer transportsLock.RUnlock()
+
+	var transport, ok = registeredTransports[strings.ToLower(name)]
+	return transport, ok && transport != nil
+}
+
+// kind returns the name of the transport used to retrieve this endpoint: its explicit kind, else
+// the kind implied by its configuration, else the scheme of its (rendered) URL if a transport is
+// registered for it, else DefaultTransport.
+func (endpoint *Endpoint) kind(renderedURL string) string {
+	if endpoint.Kind != `` {
+		return endpoint.Kind
+	} else if endpoint.SQL != nil {
+		return `sql`
+	} else if endpoint.Exec != nil {
+		return `exec`
+	} else if endpoint.GRPC != nil {
+		return `grpc`
+	} else if endpoint.Stream != nil {
+		return `stream`
+	} else if u, err := url.Parse(renderedURL); err == nil && u.Scheme != `` {
+		if _, ok := GetTransport(u.Scheme); ok {
+			return u.Scheme
+		}
+	}
+
+	return DefaultTransport
+}
+
+// transport returns the transport used to retrieve this endpoint.
+func (endpoint *Endpoint) transport(renderedURL string) (Transport, error) {
+	var kind = endpoint.kind(renderedURL)
+
+	if transport, ok := GetTransport(kind); ok {
+		return transport, nil
+	} else {
+		return nil, fmt.Errorf("no transport registered for endpoint kind %q", kind)
+	}
+}
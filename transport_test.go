@@ -0,0 +1,81 @@
+package orchestra
+
+import (
+	"context"
+	"testing"
+
+	"github.com/ghetzel/testify/require"
+)
+
+func TestCustomTransport(t *testing.T) {
+	var assert = require.New(t)
+
+	RegisterTransport(`Inventory`, TransportFunc(func(ctx context.Context, endpoint *Endpoint, request *Request) (any, error) {
+		request.Context[`inventory`] = true
+
+		return map[string]any{
+			`url`:   request.URL,
+			`sku`:   request.Params[`sku`],
+			`label`: request.Render(`{{ .vars.prefix }}-{{ .params.sku }}`),
+		}, nil
+	}))
+
+	defer func() {
+		transportsLock.Lock()
+		delete(registeredTransports, `inventory`)
+		transportsLock.Unlock()
+	}()
+
+	var opts = NewQueryOptions()
+	opts.Params[`sku`] = `abc`
+	opts.Variables = map[string]any{
+		`prefix`: `item`,
+	}
+
+	// selected by URL scheme
+	var response, err = opts.Query(&Endpoint{
+		URL: `inventory://warehouse/{{ .vars.prefix }}`,
+		ResultFilters: []any{
+			`$merge([$, {"filtered": true}])`,
+		},
+	})
+
+	assert.NoError(err)
+	assert.Equal(map[string]any{
+		`url`:      `inventory://warehouse/item`,
+		`sku`:      `abc`,
+		`label`:    `item-abc`,
+		`filtered`: true,
+	}, response.Result)
+	assert.Equal(true, response.Context[`inventory`])
+
+	// selected by kind
+	response, err = opts.Query(&Endpoint{
+		Kind: `inventory`,
+		URL:  `https://example.com`,
+	})
+
+	assert.NoError(err)
+	assert.Equal(`https://example.com`, response.Result.(map[string]any)[`url`])
+
+	_, err = opts.Query(&Endpoint{
+		Kind: `nope`,
+	})
+
+	assert.Error(err)
+	assert.Contains(err.Error(), `no transport registered for endpoint kind "nope"`)
+}
+
+func TestEndpointKind(t *testing.T) {
+	var assert = require.New(t)
+
+	assert.Equal(`https`, (&Endpoint{}).kind(`https://example.com`))
+	assert.Equal(`http`, (&Endpoint{}).kind(`unknown://example.com`))
+	assert.Equal(`file`, (&Endpoint{}).kind(`file:///tmp/x.json`))
+	assert.Equal(`ws`, (&Endpoint{}).kind(`ws://example.com`))
+	assert.Equal(`sql`, (&Endpoint{SQL: &SQLConfig{}}).kind(``))
+	assert.Equal(`exec`, (&Endpoint{Exec: &ExecConfig{}}).kind(``))
+	assert.Equal(`grpc`, (&Endpoint{GRPC: &GRPCConfig{}}).kind(``))
+	assert.Equal(`stream`, (&Endpoint{Stream: &StreamConfig{}}).kind(`https://example.com`))
+	assert.Equal(`custom`, (&Endpoint{Kind: `custom`, SQL: &SQLConfig{}}).kind(``))
+}
@@ -1,12 +1,15 @@
 package orchestra
 
 import (
+	"context"
 	"fmt"
 	"html/template"
 	"regexp"
 	"strings"
+	texttemplate "text/template"
 
 	"github.com/blues/jsonata-go"
+	"github.com/ghetzel/go-stockutil/sliceutil"
 	"github.com/ghetzel/go-stockutil/typeutil"
 )
 
@@ -58,7 +61,7 @@ func applyJsonataExpressions(data any, vars map[string]any, exprs ...*jsonata.Ex
 			if out, err := expr.Eval(data); err == nil {
 				data = out
 			} else {
-				return nil, fmt.Errorf("eval: %v", err)
+				return nil, fmt.Errorf("eval: %w", err)
 			}
 		} else {
 			return nil, fmt.Errorf("vars: %v", err)
@@ -96,3 +99,72 @@ func FormatString(format string, data map[string]any) string {
 		return fmt.Sprintf("#{! error: %v !}#", err)
 	}
 }
+
+// withTimeout derives a cancelable context from ctx that, if the given timeout parses to a positive
+// duration, will also expire after that long.
+func withTimeout(ctx context.Context, timeout string) (context.Context, context.CancelFunc) {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+
+	if d := typeutil.Duration(timeout); d > 0 {
+		return context.WithTimeout(ctx, d)
+	} else {
+		return context.WithCancel(ctx)
+	}
+}
+
+// validateTimeout checks that a timeout, if one is given at all, is a positive duration; anything
+// else would silently mean no timeout.
+func validateTimeout(timeout string) error {
+	if timeout != `` && typeutil.Duration(timeout) <= 0 {
+		return fmt.Errorf("timeout must be a positive duration, got %q", timeout)
+	}
+
+	return nil
+}
+
+// renderTemplates walks value, rendering every string it contains as a template against data.
+// Unlike FormatString, output is not HTML-escaped since the result is destined for request bodies.
+func renderTemplates(value any, data map[string]any) any {
+	switch v := value.(type) {
+	case string:
+		if !strings.Contains(v, `{{`) {
+			return v
+		}
+
+		var out = new(strings.Builder)
+
+		if tpl, err := texttemplate.New(``).Parse(v); err != nil {
+			return fmt.Sprintf("#{! error: %v !}#", err)
+		} else if err := tpl.Execute(out, data); err != nil {
+			return fmt.Sprintf("#{! error: %v !}#", err)
+		}
+
+		return out.String()
+	case map[string]any:
+		var out = make(map[string]any, len(v))
+
+		for k, sub := range v {
+			out[k] = renderTemplates(sub, data)
+		}
+
+		return out
+	case []any:
+		var out = make([]any, len(v))
+
+		for i, sub := range v {
+			out[i] = renderTemplates(sub, data)
+		}
+
+		return out
+	default:
+		if typeutil.IsMap(v) {
+			return renderTemplates(typeutil.MapNative(v), data)
+		} else if typeutil.IsArray(v) {
+			return renderTemplates(sliceutil.Sliceify(v), data)
+		}
+
+		return v
+	}
+}